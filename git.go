@@ -36,6 +36,67 @@ func ResolveRedirect(u string) (*url.URL, error) {
 	return res.Request.URL, nil
 }
 
+// NormalizeRemoteURL converts Git remote URL forms such as scp-style and ssh:// into HTTPS URL.
+// Other URLs and local paths are returned as-is.
+//
+// - git@github.com:user/repo.git → https://github.com/user/repo.git
+// - ssh://git@github.com:22/user/repo.git → https://github.com/user/repo.git
+// - git://github.com/user/repo.git → https://github.com/user/repo.git
+func NormalizeRemoteURL(s string) string {
+	for _, p := range []string{"ssh://", "git+ssh://", "ssh+git://", "git://"} {
+		if r, ok := strings.CutPrefix(s, p); ok {
+			host, path, _ := strings.Cut(r, "/")
+			if i := strings.LastIndexByte(host, '@'); i >= 0 {
+				host = host[i+1:] // Remove user
+			}
+			if strings.HasPrefix(host, "[") {
+				if i := strings.IndexByte(host, ']'); i >= 0 {
+					host = host[:i+1] // Remove port of SSH after IPv6 address like [::1]:22
+				}
+			} else if i := strings.LastIndexByte(host, ':'); i >= 0 {
+				host = host[:i] // Remove port of SSH
+			}
+			return "https://" + host + "/" + path
+		}
+	}
+
+	if strings.Contains(s, "://") {
+		return s
+	}
+
+	// scp-style URL like [user@]host:path. Like Git, it is a local path when '/' appears before the
+	// first ':' or it starts with a DOS drive like C:\repo.
+	colon := strings.IndexByte(s, ':')
+	if colon <= 0 || strings.ContainsRune(s[:colon], '/') || isDOSDrivePrefix(s) {
+		return s
+	}
+	start := 0
+	if i := strings.IndexByte(s[:colon], '@'); i >= 0 {
+		start = i + 1
+	}
+	host, path := s[start:colon], s[colon+1:]
+	if strings.HasPrefix(host, "[") {
+		// IPv6 address like git@[::1]:user/repo.git
+		i := strings.Index(s[start:], "]:")
+		if i < 0 {
+			return s
+		}
+		host, path = s[start:start+i+1], s[start+i+2:]
+	}
+	if host == "" {
+		return s
+	}
+	return "https://" + host + "/" + strings.TrimPrefix(path, "/")
+}
+
+func isDOSDrivePrefix(s string) bool {
+	if len(s) < 2 || s[1] != ':' {
+		return false
+	}
+	c := s[0]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
 // Git represents Git command for specific repository
 type Git struct {
 	bin  string
@@ -104,14 +165,7 @@ func (git *Git) FirstRemoteURL() (*url.URL, error) {
 	}
 	slog.Debug("Got config for remote URL", "config", c, "url", s)
 
-	if strings.HasPrefix(s, "git@") && strings.ContainsRune(s, ':') {
-		// git@github.com:user/repo.git → https://github.com/user/repo.git
-		s = "https://" + strings.Replace(strings.TrimPrefix(s, "git@"), ":", "/", 1)
-	} else if strings.HasPrefix(s, "ssh://git@") {
-		// ssh://git@github.com/user/repo.git → https://github.com/user/repo.git
-		s = "https://" + strings.TrimPrefix(s, "ssh://git@")
-	}
-
+	s = NormalizeRemoteURL(s)
 	if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
 		return nil, fmt.Errorf("repository URL is neither HTTP nor HTTPS: %s", s)
 	}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeRemoteURL(t *testing.T) {
+	tests := []struct {
+		what string
+		url  string
+		want string
+	}{
+		{"scp-style", "git@github.com:u/r.git", "https://github.com/u/r.git"},
+		{"scp-style without user", "github.com:u/r", "https://github.com/u/r"},
+		{"scp-style with IPv6 address", "git@[::1]:u/r.git", "https://[::1]/u/r.git"},
+		{"ssh", "ssh://git@github.com/u/r.git", "https://github.com/u/r.git"},
+		{"ssh with port", "ssh://git@github.com:22/u/r", "https://github.com/u/r"},
+		{"ssh with IPv6 address", "ssh://git@[::1]/u/r", "https://[::1]/u/r"},
+		{"ssh with IPv6 address and port", "ssh://git@[::1]:22/u/r", "https://[::1]/u/r"},
+		{"git+ssh", "git+ssh://git@github.com/u/r.git", "https://github.com/u/r.git"},
+		{"ssh+git", "ssh+git://git@github.com/u/r.git", "https://github.com/u/r.git"},
+		{"git protocol", "git://github.com/u/r.git", "https://github.com/u/r.git"},
+		{"GitLab subgroup", "git@gitlab.com:g/s/r.git", "https://gitlab.com/g/s/r.git"},
+		{"HTTPS", "https://github.com/u/r", "https://github.com/u/r"},
+		{"HTTP", "http://example.com/u/r", "http://example.com/u/r"},
+		{"local path", "/path/to/repo", "/path/to/repo"},
+		{"relative local path", "./foo:bar", "./foo:bar"},
+		{"Windows path with backslash", `C:\repo`, `C:\repo`},
+		{"Windows path with slash", "C:/repo", "C:/repo"},
+		{"Windows drive without separator", "c:repo", "c:repo"},
+		{"empty host", ":u/r", ":u/r"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			if have := NormalizeRemoteURL(tc.url); have != tc.want {
+				t.Errorf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
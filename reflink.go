@@ -4,23 +4,203 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
+// RefKind is a kind of reference detected in markdown text.
+type RefKind string
+
+const (
+	// RefIssue is an issue reference like #123.
+	RefIssue RefKind = "issue"
+	// RefUser is a user reference like @foo. Organization mentions like @github are also user
+	// references since organizations share the namespace with users.
+	RefUser RefKind = "user"
+	// RefCommit is a commit hash reference like 93e1af6ec49d23397baba466fba1e89cc8b6de39 or
+	// owner/repo@93e1af6.
+	RefCommit RefKind = "commit"
+	// RefExt is an external reference like GH-123 or custom autolinks.
+	RefExt RefKind = "ext"
+	// RefIssueURL is an issue or pull request URL.
+	RefIssueURL RefKind = "issue-url"
+	// RefCommitURL is a commit URL.
+	RefCommitURL RefKind = "commit-url"
+	// RefMergeRequest is a GitLab merge request reference like !123.
+	RefMergeRequest RefKind = "merge-request"
+	// RefMilestone is a GitLab milestone reference like %123.
+	RefMilestone RefKind = "milestone"
+	// RefVersion is a version string like v1.2.3. See LinkVersionTags.
+	RefVersion RefKind = "version"
+	// RefReleaseURL is a release page URL.
+	RefReleaseURL RefKind = "release-url"
+	// RefCompareURL is a URL to compare two revisions.
+	RefCompareURL RefKind = "compare-url"
+	// RefBlobURL is a permalink URL to a file at some commit.
+	RefBlobURL RefKind = "blob-url"
+	// RefAdvisoryURL is a security advisory URL like .../security/advisories/GHSA-xxxx-xxxx-xxxx.
+	RefAdvisoryURL RefKind = "advisory-url"
+	// RefRegexp is a reference matched by a regular expression added with AddRegexpRef.
+	RefRegexp RefKind = "regexp"
+)
+
+// Flavor is a flavor of the service hosting the repository. It determines the syntax of references
+// and the URLs they are linked to.
+type Flavor int
+
+const (
+	// FlavorGitHub is the flavor of GitHub and GitHub Enterprise. This is the default.
+	FlavorGitHub Flavor = iota
+	// FlavorGitLab is the flavor of GitLab. Merge request references like !123 and milestone
+	// references like %123 are also linked.
+	FlavorGitLab
+)
+
+// LinkFormat is a syntax of links output by Reflinker. See Reflinker.SetLinkFormat.
+type LinkFormat int
+
+const (
+	// LinkFormatMarkdown outputs Markdown links like [#123](https://...). This is the default.
+	LinkFormatMarkdown LinkFormat = iota
+	// LinkFormatOrg outputs Org-mode links like [[https://...][#123]]. Inline code in the link text
+	// is converted into Org-mode verbatim like ~93e1af6ec4~.
+	LinkFormatOrg
+	// LinkFormatRST outputs reStructuredText hyperlinks like `#123 <https://...>`_. Inline code in
+	// the link text is output as plain text since reStructuredText does not allow nested markup.
+	LinkFormatRST
+)
+
+// LeadingZeros is how issue numbers with leading zeros like #007 are handled. See
+// Reflinker.SetLeadingZeros.
+type LeadingZeros int
+
+const (
+	// LeadingZerosKeep links the issue number as-is like #007 → /issues/007. This is the default.
+	LeadingZerosKeep LeadingZeros = iota
+	// LeadingZerosStrip removes the leading zeros from the link target while the link text is kept
+	// like #007 → /issues/7.
+	LeadingZerosStrip
+	// LeadingZerosReject does not link issue numbers starting with zero like #007 and #0.
+	LeadingZerosReject
+)
+
+// IssueKind is a kind of the resource referred by an issue reference like #123. See
+// Reflinker.SetIssuePRResolver.
+type IssueKind int
+
+const (
+	// IssueKindIssue is an issue.
+	IssueKindIssue IssueKind = iota
+	// IssueKindPullRequest is a pull request.
+	IssueKindPullRequest
+)
+
+// DefaultMaxInputSize is the default maximum size of input in bytes. See Reflinker.SetMaxInputSize.
+const DefaultMaxInputSize = 64 * 1024 * 1024
+
+// DefaultMaxUsernameLength is the maximum length of GitHub usernames. See
+// Reflinker.SetMaxUsernameLength.
+const DefaultMaxUsernameLength = 39
+
+const hexChars = "0123456789abcdef"
+
+// refTriggers returns the characters which may start a reference in the flavor.
+func (f Flavor) refTriggers() string {
+	switch f {
+	case FlavorGitLab:
+		return "#@!%" + hexChars
+	default:
+		return "#@" + hexChars
+	}
+}
+
+// Ref is a reference detected in markdown text which is about to be linked.
+type Ref struct {
+	// Kind is a kind of the reference.
+	Kind RefKind `json:"kind"`
+	// Text is the original text of the reference like "#123".
+	Text string `json:"text"`
+	// URL is the URL which the reference is linked to.
+	URL string `json:"url"`
+}
+
 type replacement struct {
 	start int
 	end   int
-	text  string
+	kind  RefKind
+	label string
+	url   string // Empty when the replacement is not a link
+	// prefix is inserted before the link. It is "[]" when the link follows a shortcut reference link
+	// like [foo]#123 so that [foo] is not treated as the text of a full reference link [foo][#123].
+	// It is "\\" when the link follows a literal backslash like \41608e5f41 so that the backslash does
+	// not escape '['.
+	prefix string
+	issue  int // Issue number when the reference is to an issue or a pull request in the repository
+	format LinkFormat
+}
+
+func (r *replacement) text() string {
+	if r.url == "" {
+		return r.label
+	}
+	return string(r.appendText(nil))
+}
+
+func (r *replacement) appendText(dst []byte) []byte {
+	if r.url == "" {
+		return append(dst, r.label...)
+	}
+	switch r.format {
+	case LinkFormatOrg:
+		dst = append(dst, "[["...)
+		dst = append(dst, r.url...)
+		dst = append(dst, "]["...)
+		dst = append(dst, strings.ReplaceAll(r.label, "`", "~")...)
+		return append(dst, "]]"...)
+	case LinkFormatRST:
+		dst = append(dst, '`')
+		dst = append(dst, strings.ReplaceAll(r.label, "`", "")...)
+		dst = append(dst, " <"...)
+		dst = append(dst, r.url...)
+		return append(dst, ">`_"...)
+	default:
+		dst = append(dst, r.prefix...)
+		dst = append(dst, '[')
+		dst = append(dst, r.label...)
+		dst = append(dst, "]("...)
+		dst = append(dst, r.url...)
+		return append(dst, ')')
+	}
+}
+
+func (r *replacement) ref(src []byte) Ref {
+	return Ref{
+		Kind: r.kind,
+		Text: string(src[r.start:r.end]),
+		URL:  r.url,
+	}
 }
 
 type byStartOffset []replacement
@@ -40,389 +220,2751 @@ func isUserNameChar(b byte) bool {
 	return '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '-'
 }
 
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func isRepoNameChar(b byte) bool {
+	return isUserNameChar(b) || b == '_' || b == '.'
+}
+
+// isValidSlug returns true when the owner and the repository names conform to GitHub naming rules.
+// An owner name is up to 39 alphanumeric characters or hyphens and cannot begin or end with a hyphen.
+// A repository name is up to 100 alphanumeric characters, '.', '_', or '-' and cannot be '.' or '..'.
+func isValidSlug(owner, repo []byte) bool {
+	if len(owner) == 0 || len(owner) > 39 || owner[0] == '-' || owner[len(owner)-1] == '-' {
+		return false
+	}
+	for _, b := range owner {
+		if !isUserNameChar(b) {
+			return false
+		}
+	}
+	if len(repo) == 0 || len(repo) > 100 || string(repo) == "." || string(repo) == ".." {
+		return false
+	}
+	for _, b := range repo {
+		if !isRepoNameChar(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// isURLSlug returns true when the owner and the repository names in a URL consist of the characters
+// allowed in repository names. This is looser than isValidSlug since owner names in URLs on GitHub
+// Enterprise may contain '_' like corp_emu.
+func isURLSlug(owner, repo []byte) bool {
+	if len(owner) == 0 || len(repo) == 0 || string(repo) == "." || string(repo) == ".." {
+		return false
+	}
+	for _, b := range owner {
+		if !isRepoNameChar(b) {
+			return false
+		}
+	}
+	for _, b := range repo {
+		if !isRepoNameChar(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// isLatinLetterOrMark returns true when the text starts with a non-ASCII Latin letter like 'é' or a
+// combining mark like U+0301. They look like a part of the preceding word.
+func isLatinLetterOrMark(b []byte) bool {
+	r, _ := utf8.DecodeRune(b)
+	return unicode.Is(unicode.Latin, r) || unicode.IsMark(r)
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+type regexpRef struct {
+	pat *regexp.Regexp
+	url string
+}
+
 type extRef struct {
 	prefix string
 	pat    *regexp.Regexp
 	url    string
 }
 
-// Reflinker detects all references in markdown text and replaces them with links.
-type Reflinker struct {
-	repo string
-	home string
-	src  []byte
-	ext  []extRef
-	reps []replacement
+// Reflinker detects all references in markdown text and replaces them with links. Once configured,
+// its methods to link references are safe for concurrent use. Options must not be changed during
+// the calls.
+type Reflinker struct {
+	repo           string
+	home           string
+	src            []byte
+	orig           []byte // The same as src unless full-width sigils are normalized
+	bom            bool
+	ext            []extRef
+	regexps        []regexpRef
+	reps           []replacement
+	mentionSpace   bool
+	foreignHosts   bool
+	looseIssue     bool
+	users          map[string]struct{}
+	deniedIssues   map[int]struct{}
+	validator      func(Ref) bool
+	fullSHA        bool
+	flavor         Flavor
+	version        *regexp.Regexp
+	disabled       bool
+	transformURL   func(RefKind, string) string
+	maxTagLen      int
+	firstOnly      bool
+	relative       bool
+	relativeKinds  []RefKind
+	upperSHA       bool
+	noIndentedCode bool
+	commentNote    string
+	reviewNote     string
+	fullWidth      bool
+	issueURL       string
+	shaLen         int
+	compareLabel   string
+	leadingZeros   LeadingZeros
+	resolveIssue   func(int) IssueKind
+	maxInput       int
+	maxUserLen     int
+	debugLog       func(format string, args ...any)
+	format         LinkFormat
+	shortcuts      []int // End offsets of shortcut reference links like [foo]
+	inlineLinks    []inlineLink
+	ticks          []int // Offsets of backticks not in code spans in the current block
+	noVerify       bool  // True while verifying the output in stabilize
+	extPriority    bool
+	userURL        string
+	mentionTrigs   [][]byte
+	issueRanges    bool
+}
+
+// ExtRefConfig is a configuration of external reference. See Reflinker.AddExtRef.
+type ExtRefConfig struct {
+	Prefix       string
+	URL          string
+	Alphanumeric bool
+	// NoBoundary is the negation of ExtRefOpts.RequireBoundary.
+	NoBoundary bool
+}
+
+// ExtRefOpts is options of external reference added by Reflinker.AddExtRefOpts.
+type ExtRefOpts struct {
+	// Alphanumeric is the same as the parameter of AddExtRef.
+	Alphanumeric bool
+	// RequireBoundary requires the prefix not to follow a word character. When false, the reference
+	// is also matched inside a word like ABC-123 in refABC-123.
+	RequireBoundary bool
+}
+
+// RegexpRefConfig is a configuration of reference matched by a regular expression. See
+// Reflinker.AddRegexpRef.
+type RegexpRefConfig struct {
+	Pattern *regexp.Regexp
+	URL     string
+}
+
+// ReflinkerConfig is a configuration to create Reflinker instance with NewReflinkerConfig. The zero
+// values of the fields except for RepoURL are the defaults. Each field corresponds to the setter of
+// Reflinker.
+type ReflinkerConfig struct {
+	// RepoURL is a repository URL of the service like https://github.com/user/repo. This is required.
+	// Git remote URLs like git@github.com:user/repo.git are converted into HTTPS URLs.
+	RepoURL string
+	// Flavor is the flavor of the service. See SetFlavor.
+	Flavor Flavor
+	// ExtRefs is a list of external references in addition to the default GH- reference. See AddExtRef.
+	ExtRefs []ExtRefConfig
+	// RegexpRefs is a list of references matched by regular expressions. See AddRegexpRef.
+	RegexpRefs []RegexpRefConfig
+	// MentionRequiresLeadingSpace is set by SetMentionRequiresLeadingSpace.
+	MentionRequiresLeadingSpace bool
+	// LinkForeignHosts is set by LinkForeignHosts.
+	LinkForeignHosts bool
+	// NoIssueRefBoundaryBefore is the negation of SetIssueRefRequireBoundaryBefore.
+	NoIssueRefBoundaryBefore bool
+	// UserAllowlist is set by SetUserAllowlist.
+	UserAllowlist []string
+	// IssueDenylist is set by SetIssueDenylist.
+	IssueDenylist []int
+	// RefValidator is set by SetRefValidator.
+	RefValidator func(Ref) bool
+	// ShowFullSHA is set by SetShowFullSHA.
+	ShowFullSHA bool
+	// VersionTagPrefix is set by LinkVersionTags when LinkVersionTags field is true.
+	VersionTagPrefix string
+	// LinkVersionTags enables LinkVersionTags with VersionTagPrefix.
+	LinkVersionTags bool
+	// Disabled is the negation of SetEnabled.
+	Disabled bool
+	// URLTransformer is set by SetURLTransformer.
+	URLTransformer func(kind RefKind, url string) string
+	// MaxTagNameLength is set by SetMaxTagNameLength.
+	MaxTagNameLength int
+	// LinkFirstOccurrenceOnly is set by SetLinkFirstOccurrenceOnly.
+	LinkFirstOccurrenceOnly bool
+	// RelativeLinks is set by SetRelativeLinks.
+	RelativeLinks bool
+	// RelativeKinds is set by SetRelativeKinds.
+	RelativeKinds []RefKind
+	// AcceptUppercaseSHA is set by SetAcceptUppercaseSHA.
+	AcceptUppercaseSHA bool
+	// NoIndentedCodeBlocks is the negation of SetIndentedCodeBlocks.
+	NoIndentedCodeBlocks bool
+	// CommentSuffix and ReviewSuffix are set by SetReferenceCommentFormat. nil means the default
+	// suffix.
+	CommentSuffix, ReviewSuffix *string
+	// NormalizeFullWidth is set by SetNormalizeFullWidth.
+	NormalizeFullWidth bool
+	// IssueURLTemplate is set by SetIssueURLTemplate. It must contain <num> placeholder when it is
+	// not empty.
+	IssueURLTemplate string
+	// CommitSHALength is set by SetCommitSHALength.
+	CommitSHALength int
+	// CompareLabel is set by SetCompareLabel.
+	CompareLabel string
+	// LeadingZeros is set by SetLeadingZeros.
+	LeadingZeros LeadingZeros
+	// IssuePRResolver is set by SetIssuePRResolver.
+	IssuePRResolver func(num int) IssueKind
+	// LinkFormat is set by SetLinkFormat.
+	LinkFormat LinkFormat
+	// DebugLogger is set by SetDebugLogger.
+	DebugLogger func(format string, args ...any)
+	// MaxInputSize is set by SetMaxInputSize. Zero means DefaultMaxInputSize and negative value means
+	// no limit.
+	MaxInputSize int
+	// MaxUsernameLength is set by SetMaxUsernameLength. Zero means DefaultMaxUsernameLength and
+	// negative value means no limit.
+	MaxUsernameLength int
+	// ExtRefPriority is set by SetExtRefPriority.
+	ExtRefPriority bool
+	// UserURLTemplate is set by SetUserURLTemplate. It must contain <user> placeholder and must be a
+	// valid URL when it is not empty.
+	UserURLTemplate string
+	// MentionTriggers is set by SetMentionTriggers.
+	MentionTriggers []string
+	// BasePath is set by SetBasePath. The path of RepoURL must start with it.
+	BasePath string
+	// CollapseIssueRanges is set by SetCollapseIssueRanges.
+	CollapseIssueRanges bool
+}
+
+func (c *ReflinkerConfig) validate() error {
+	u, err := url.Parse(c.RepoURL)
+	if err != nil {
+		return fmt.Errorf("invalid repository URL %q to link references: %w", c.RepoURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("repository URL %q to link references must be an absolute URL like https://github.com/owner/repo", c.RepoURL)
+	}
+	if c.IssueURLTemplate != "" && !strings.Contains(c.IssueURLTemplate, "<num>") {
+		return fmt.Errorf("issue URL template %q must contain <num> placeholder", c.IssueURLTemplate)
+	}
+	if c.BasePath != "" {
+		p := "/" + strings.Trim(c.BasePath, "/") + "/"
+		if !strings.HasPrefix(u.Path, p) {
+			return fmt.Errorf("path of repository URL %q to link references must start with the base path %q", c.RepoURL, c.BasePath)
+		}
+	}
+	if t := c.UserURLTemplate; t != "" {
+		if !strings.Contains(t, "<user>") {
+			return fmt.Errorf("user URL template %q must contain <user> placeholder", t)
+		}
+		if strings.ContainsAny(strings.NewReplacer("<user>", "", "<home>", "").Replace(t), " \t\r\n()<>") {
+			return fmt.Errorf("user URL template %q must not contain whitespaces, parentheses, or angle brackets since they break markdown links", t)
+		}
+		u, err := url.Parse(strings.NewReplacer("<user>", "user", "<home>", "https://example.com").Replace(t))
+		if err != nil {
+			return fmt.Errorf("user URL template %q is not a valid URL: %w", t, err)
+		}
+		if u.Scheme == "" {
+			return fmt.Errorf("user URL template %q must be an absolute URL with scheme like mailto:<user>@example.com", t)
+		}
+	}
+	if c.LeadingZeros < LeadingZerosKeep || c.LeadingZeros > LeadingZerosReject {
+		return fmt.Errorf("unknown handling %d of issue numbers with leading zeros", c.LeadingZeros)
+	}
+	if c.Flavor != FlavorGitHub && c.Flavor != FlavorGitLab {
+		return fmt.Errorf("unknown flavor %d to link references", c.Flavor)
+	}
+	if c.LinkFormat < LinkFormatMarkdown || c.LinkFormat > LinkFormatRST {
+		return fmt.Errorf("unknown format %d of links", c.LinkFormat)
+	}
+	for _, e := range c.ExtRefs {
+		if e.Prefix == "" {
+			return fmt.Errorf("prefix of external reference for URL %q must not be empty", e.URL)
+		}
+		if !strings.Contains(e.URL, "<num>") {
+			return fmt.Errorf("URL %q of external reference %q must contain <num> placeholder", e.URL, e.Prefix)
+		}
+	}
+	for _, r := range c.RegexpRefs {
+		if r.Pattern == nil {
+			return fmt.Errorf("pattern of regular expression reference for URL %q must not be nil", r.URL)
+		}
+	}
+	return nil
+}
+
+// NewReflinkerConfig creates Reflinker instance with the configuration. It returns an error when the
+// configuration is invalid.
+func NewReflinkerConfig(c *ReflinkerConfig) (*Reflinker, error) {
+	if u := NormalizeRemoteURL(c.RepoURL); u != c.RepoURL {
+		slog.Debug("Normalized Git remote URL to link references", "from", c.RepoURL, "to", u)
+		cfg := *c
+		cfg.RepoURL = u
+		c = &cfg
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	// Git remote URLs like https://github.com/owner/repo.git are also accepted
+	repo := strings.TrimSuffix(c.RepoURL, ".git")
+	u, _ := url.Parse(repo) // Already validated
+	u.Path = ""
+
+	l := &Reflinker{
+		repo:        repo,
+		home:        u.String(),
+		commentNote: " (comment)",
+		reviewNote:  " (review)",
+		maxInput:    DefaultMaxInputSize,
+		maxUserLen:  DefaultMaxUsernameLength,
+	}
+	l.AddExtRef("GH-", repo+"/issues/<num>", false)
+	for _, e := range c.ExtRefs {
+		l.AddExtRefOpts(e.Prefix, e.URL, ExtRefOpts{Alphanumeric: e.Alphanumeric, RequireBoundary: !e.NoBoundary})
+	}
+	for _, r := range c.RegexpRefs {
+		l.AddRegexpRef(r.Pattern, r.URL)
+	}
+
+	l.SetFlavor(c.Flavor)
+	l.SetMentionRequiresLeadingSpace(c.MentionRequiresLeadingSpace)
+	l.LinkForeignHosts(c.LinkForeignHosts)
+	l.SetIssueRefRequireBoundaryBefore(!c.NoIssueRefBoundaryBefore)
+	l.SetUserAllowlist(c.UserAllowlist)
+	l.SetIssueDenylist(c.IssueDenylist)
+	l.SetRefValidator(c.RefValidator)
+	l.SetShowFullSHA(c.ShowFullSHA)
+	if c.LinkVersionTags {
+		l.LinkVersionTags(c.VersionTagPrefix)
+	}
+	l.SetEnabled(!c.Disabled)
+	l.SetURLTransformer(c.URLTransformer)
+	l.SetMaxTagNameLength(c.MaxTagNameLength)
+	l.SetLinkFirstOccurrenceOnly(c.LinkFirstOccurrenceOnly)
+	l.SetRelativeLinks(c.RelativeLinks)
+	l.SetRelativeKinds(c.RelativeKinds...)
+	l.SetAcceptUppercaseSHA(c.AcceptUppercaseSHA)
+	l.SetIndentedCodeBlocks(!c.NoIndentedCodeBlocks)
+	if c.CommentSuffix != nil {
+		l.commentNote = *c.CommentSuffix
+	}
+	if c.ReviewSuffix != nil {
+		l.reviewNote = *c.ReviewSuffix
+	}
+	l.SetNormalizeFullWidth(c.NormalizeFullWidth)
+	l.SetIssueURLTemplate(c.IssueURLTemplate)
+	l.SetCommitSHALength(c.CommitSHALength)
+	l.SetCompareLabel(c.CompareLabel)
+	l.SetLeadingZeros(c.LeadingZeros)
+	l.SetIssuePRResolver(c.IssuePRResolver)
+	l.SetDebugLogger(c.DebugLogger)
+	l.SetLinkFormat(c.LinkFormat)
+	l.SetExtRefPriority(c.ExtRefPriority)
+	l.SetUserURLTemplate(c.UserURLTemplate)
+	l.SetMentionTriggers(c.MentionTriggers)
+	l.SetBasePath(c.BasePath)
+	l.SetCollapseIssueRanges(c.CollapseIssueRanges)
+	if c.MaxInputSize != 0 {
+		l.SetMaxInputSize(c.MaxInputSize)
+	}
+	if c.MaxUsernameLength != 0 {
+		l.SetMaxUsernameLength(c.MaxUsernameLength)
+	}
+
+	return l, nil
+}
+
+// NewReflinker creates Reflinker instance. repoURL is a repository URL of the service like
+// https://github.com/user/repo. It panics when the URL is invalid.
+func NewReflinker(repoURL string) *Reflinker {
+	l, err := NewReflinkerConfig(&ReflinkerConfig{RepoURL: repoURL})
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// SetMentionRequiresLeadingSpace sets whether user references like @foo require a whitespace or the
+// start of text before them. This is stricter than the default boundary rule and avoids linking
+// something like a part of email address in noisy text. The default value is false.
+func (l *Reflinker) SetMentionRequiresLeadingSpace(enabled bool) {
+	l.mentionSpace = enabled
+}
+
+// LinkForeignHosts sets whether issue, pull request, and commit URLs on hosts other than the
+// repository's host are shortened. For example, a github.com issue URL in the changelog of a GitHub
+// Enterprise repository is shortened to 'github.com/owner/repo#123'. The default value is false.
+func (l *Reflinker) LinkForeignHosts(enabled bool) {
+	l.foreignHosts = enabled
+}
+
+// SetIssueRefRequireBoundaryBefore sets whether issue references like #123 require a boundary
+// character before them. When false, '#123' in 'v2#123' is also linked. The default value is true.
+func (l *Reflinker) SetIssueRefRequireBoundaryBefore(required bool) {
+	l.looseIssue = !required
+}
+
+// SetUserAllowlist sets user names to be linked. User references not in the list are left as plain
+// text. User names are matched case-insensitively. Passing nil removes the allowlist so that all
+// valid user references are linked, which is the default.
+func (l *Reflinker) SetUserAllowlist(names []string) {
+	if names == nil {
+		l.users = nil
+		return
+	}
+	l.users = make(map[string]struct{}, len(names))
+	for _, n := range names {
+		l.users[strings.ToLower(strings.TrimPrefix(n, "@"))] = struct{}{}
+	}
+}
+
+// SetIssueDenylist sets issue numbers which are never linked. For example, denying 1 prevents '#1'
+// meaning "number one" in prose from being linked. The default is empty.
+func (l *Reflinker) SetIssueDenylist(nums []int) {
+	l.deniedIssues = make(map[int]struct{}, len(nums))
+	for _, n := range nums {
+		l.deniedIssues[n] = struct{}{}
+	}
+}
+
+// SetRefValidator sets a callback to validate each reference before it is linked. When the callback
+// returns false, the reference is left as plain text. This is useful to link only existing issues or
+// users when they are known. Passing nil removes the validator.
+func (l *Reflinker) SetRefValidator(f func(Ref) bool) {
+	l.validator = f
+}
+
+// SetURLTransformer sets a callback to transform the target URL of each generated link. The kind of
+// the reference is also passed. This is useful for routing links through a proxy or adding query
+// parameters. The validator set by SetRefValidator receives the URL before the transformation.
+// Passing nil removes the transformer.
+func (l *Reflinker) SetURLTransformer(f func(kind RefKind, url string) string) {
+	l.transformURL = f
+}
+
+// SetMaxTagNameLength sets the maximum number of characters of tag names displayed in the links of
+// release and compare URLs. Longer tag names are truncated with an ellipsis. The link target is
+// the same regardless of this option. Zero or a negative value means no truncation, which is the
+// default.
+func (l *Reflinker) SetMaxTagNameLength(n int) {
+	l.maxTagLen = n
+}
+
+// SetLinkFirstOccurrenceOnly sets whether only the first occurrence of each reference is linked in
+// a single call. The following references of the same kind linking to the same URL are left as
+// plain text. This reduces clutter in a long changelog. The default value is false.
+func (l *Reflinker) SetLinkFirstOccurrenceOnly(enabled bool) {
+	l.firstOnly = enabled
+}
+
+// SetRelativeLinks sets whether links to resources in the same repository are output as root-relative
+// paths like /owner/repo/issues/123 instead of absolute URLs. They are resolved on the same host
+// wherever the text is rendered like a release page or CHANGELOG.md. Links to other repositories
+// and users are still absolute. This is applied after the URL transformer set by SetURLTransformer.
+// The default value is false.
+func (l *Reflinker) SetRelativeLinks(enabled bool) {
+	l.relative = enabled
+}
+
+// SetRelativeKinds restricts the relative links enabled by SetRelativeLinks to the given kinds of
+// references. Links for other kinds are output as absolute URLs. For example, passing RefIssue and
+// RefIssueURL keeps commit links absolute for consumers rendering the output outside GitHub. Calling
+// this with no argument makes links for all kinds relative, which is the default.
+func (l *Reflinker) SetRelativeKinds(kinds ...RefKind) {
+	l.relativeKinds = slices.Clone(kinds)
+}
+
+// SetAcceptUppercaseSHA sets whether commit hashes containing uppercase hex characters like
+// 41608E5F... are linked. They are normalized to lowercase in the links. By default only lowercase
+// commit hashes are linked since uppercase hex strings like DEADBEEF are often not commit hashes.
+func (l *Reflinker) SetAcceptUppercaseSHA(enabled bool) {
+	l.upperSHA = enabled
+}
+
+// SetIndentedCodeBlocks sets whether lines indented with 4 or more spaces are treated as code blocks
+// as CommonMark specifies. References in code blocks are not linked. When false, indented lines are
+// parsed as if they were not indented so references, inline markups and URLs in them are handled as
+// usual. Note that the indentation is kept in the output so GitHub and other CommonMark renderers
+// still render the lines as a code block, where the links are shown as raw markdown text. Dedent the
+// output before rendering it. This is useful when release notes are pasted with indentation. Fenced
+// code blocks are not affected. The default value is true.
+func (l *Reflinker) SetIndentedCodeBlocks(enabled bool) {
+	l.noIndentedCode = !enabled
+}
+
+// SetReferenceCommentFormat sets the suffixes of the link texts for issue and pull request URLs
+// with fragments. The comment suffix is for URLs to comments like #issuecomment-12345 and the review
+// suffix is for URLs to pull request reviews like #pullrequestreview-12345. An empty string removes
+// the suffix. The defaults are " (comment)" and " (review)".
+func (l *Reflinker) SetReferenceCommentFormat(comment, review string) {
+	l.commentNote = comment
+	l.reviewNote = review
+}
+
+// SetNormalizeFullWidth sets whether full-width '＃' and '＠' typed with CJK input methods are
+// treated as ASCII '#' and '@' so that the references like ＃123 and ＠foo are linked like [#123](...).
+// Only the sigils in the linked references are replaced. Other full-width characters are output as
+// they are. GitHub does not link them. The default value is false.
+func (l *Reflinker) SetNormalizeFullWidth(enabled bool) {
+	l.fullWidth = enabled
+}
+
+// SetIssueURLTemplate sets the URL template of issue references like #123 in the repository. <num>
+// in the template is replaced with the issue number and <repo> is replaced with the repository URL.
+// This is useful when issues are managed in an organization-wide issue tracker. Cross-repository
+// references like foo/bar#123 and issue URLs are not affected. An empty string means the default
+// template "<repo>/issues/<num>".
+func (l *Reflinker) SetIssueURLTemplate(tmpl string) {
+	l.issueURL = tmpl
+}
+
+// SetIssuePRResolver sets a callback to resolve whether an issue reference in the repository like
+// #123, owner/repo#123, or GH-123 refers to an issue or a pull request. Issue and pull request
+// references cannot be distinguished offline, but applications may know it from cached data.
+// References resolved as pull requests are linked to the pull request pages and their link texts are
+// prefixed with "PR " like "PR #123". The template set by
+// SetIssueURLTemplate is not applied to them. Without the resolver, all references are linked to the
+// issue pages and GitHub redirects them to pull requests. Passing nil removes the resolver.
+func (l *Reflinker) SetIssuePRResolver(f func(num int) IssueKind) {
+	l.resolveIssue = f
+}
+
+// SetMaxInputSize sets the maximum size of input in bytes. Inputs larger than the size are returned
+// as-is without parsing them. This protects servers from huge inputs. Zero or negative value means no
+// limit. The default value is DefaultMaxInputSize.
+func (l *Reflinker) SetMaxInputSize(n int) {
+	l.maxInput = n
+}
+
+// SetMaxUsernameLength sets the maximum number of characters of user names in user references on
+// GitHub. Longer user names like @aaa...aaa are not valid on GitHub so they are not linked at all.
+// Zero or negative value means no limit. The default value is DefaultMaxUsernameLength. This option
+// is ignored on GitLab.
+func (l *Reflinker) SetMaxUsernameLength(n int) {
+	l.maxUserLen = n
+}
+
+// SetLinkFormat sets the syntax of output links. This is useful to convert the changelog into other
+// formats like Org-mode or reStructuredText. Note that only the links are output in the format and
+// the rest of the text is kept as-is. The default value is LinkFormatMarkdown.
+func (l *Reflinker) SetLinkFormat(f LinkFormat) {
+	l.format = f
+}
+
+// SetDebugLogger sets a logger called with the explanations of why references were not linked like
+// "skipped #123: preceded by non-boundary 'v'". This is useful to diagnose missing links. The format
+// and the arguments are the same as fmt.Printf. Passing nil removes the logger, which is the default.
+func (l *Reflinker) SetDebugLogger(f func(format string, args ...any)) {
+	l.debugLog = f
+}
+
+// skipped explains why the reference was not linked with the logger set by SetDebugLogger.
+func (l *Reflinker) skipped(ref []byte, format string, args ...any) {
+	if l.debugLog == nil {
+		return
+	}
+	l.debugLog("skipped %s: "+format, append([]any{ref}, args...)...)
+}
+
+// tokenAt returns the reference-like token at the offset for debug logs, e.g. #123 in v#123.
+func (l *Reflinker) tokenAt(offset, end int) []byte {
+	e := offset + 1
+	for e < end && !isBoundary(l.src[e]) || e < end && l.src[e] == '-' {
+		e++
+	}
+	return l.src[offset:e]
+}
+
+// SetExtRefPriority sets whether external references added by AddExtRef take precedence over
+// built-in references like #123 and @foo when their ranges overlap. For example, when "JIRA-" is
+// added as an external reference, @JIRA-1 is linked as the external reference JIRA-1 with this
+// option enabled. Otherwise the earlier reference is linked and built-in references are preferred
+// when they start at the same position. The default value is false.
+func (l *Reflinker) SetExtRefPriority(enabled bool) {
+	l.extPriority = enabled
+}
+
+// SetUserURLTemplate sets the URL template of user references like @foo. <user> in the template is
+// replaced with the user name and <home> is replaced with the home URL of the service like
+// https://github.com. Any scheme is allowed so that mentions can be linked to internal directories
+// like "mailto:<user>@example.com" or "slack://user?team=T123&id=<user>". An empty string means the
+// default template "<home>/<user>". Use ReflinkerConfig.UserURLTemplate to validate the template.
+func (l *Reflinker) SetUserURLTemplate(tmpl string) {
+	l.userURL = tmpl
+}
+
+// SetMentionTriggers sets the phrases like "thanks to", "by", or "cc" which must precede user references
+// in the same line. User references without any trigger phrase before them in the line are not linked.
+// This reduces false-positive mentions in prose. The phrases are matched case-insensitively as whole
+// words. Empty or nil slice means all user references are linked, which is the default.
+func (l *Reflinker) SetMentionTriggers(phrases []string) {
+	l.mentionTrigs = nil
+	for _, p := range phrases {
+		if p != "" {
+			l.mentionTrigs = append(l.mentionTrigs, bytes.ToLower([]byte(p)))
+		}
+	}
+}
+
+// SetBasePath sets the base path of the service for GitHub Enterprise hosted under a subpath. For
+// example, when the repository URL is https://corp.example.com/github/owner/repo, the base path is
+// "/github" and users are linked to https://corp.example.com/github/user. The base path must be a
+// prefix of the path of the repository URL. An empty string means the service is hosted at the root
+// of the host, which is the default.
+func (l *Reflinker) SetBasePath(p string) {
+	u, _ := url.Parse(l.repo) // Already validated
+	u.Path = ""
+	u.RawPath = ""
+	if p := strings.Trim(p, "/"); p != "" {
+		u.Path = "/" + p
+	}
+	l.home = u.String()
+}
+
+// SetCollapseIssueRanges sets whether runs of 3 or more consecutive issue references like
+// "#10 #11 #12 #13" are collapsed into a single link "[#10–#13](...)" to the first issue. The
+// references must be separated only by spaces or commas. This is cosmetic and the default value is
+// false.
+func (l *Reflinker) SetCollapseIssueRanges(enabled bool) {
+	l.issueRanges = enabled
+}
+
+// SetShowFullSHA sets whether commit hashes are displayed without abbreviation in commit references
+// and commit URLs. The link target is the same regardless of this option. The default value is false.
+func (l *Reflinker) SetShowFullSHA(enabled bool) {
+	l.fullSHA = enabled
+}
+
+// SetCommitSHALength sets the number of characters of abbreviated commit hashes displayed in commit
+// references and commit URLs. Hashes shorter than the length are displayed as-is. Zero or negative
+// value means the default length 10. SetShowFullSHA takes precedence over this option.
+func (l *Reflinker) SetCommitSHALength(n int) {
+	l.shaLen = n
+}
+
+// SetCompareLabel sets the fixed link text of compare URLs like "Full Changelog". This is useful
+// since the range of a compare URL like v1.0.0...v2.0.0 can be long. An empty string means the range
+// is shown as the link text. The default value is an empty string.
+func (l *Reflinker) SetCompareLabel(label string) {
+	l.compareLabel = label
+}
+
+// SetLeadingZeros sets how issue numbers with leading zeros like #007 are handled. Such issue numbers
+// are usually typos or zero-padded numbers and the links to them may be broken. This is applied to
+// issue references including cross-repository references and GitLab references. GitHub itself does
+// not link issue numbers starting with zero like #0 and #007, so LeadingZerosReject matches GitHub's
+// rendering. The default value is LeadingZerosKeep.
+func (l *Reflinker) SetLeadingZeros(z LeadingZeros) {
+	l.leadingZeros = z
+}
+
+// SetFlavor sets the flavor of the service hosting the repository. The default value is FlavorGitHub.
+func (l *Reflinker) SetFlavor(f Flavor) {
+	l.flavor = f
+}
+
+// SetEnabled sets whether references are linked. When false, Link and other methods to link
+// references return the input as-is without parsing it. This is useful to toggle linking without
+// changing call sites. The default value is true.
+func (l *Reflinker) SetEnabled(enabled bool) {
+	l.disabled = !enabled
+}
+
+// LinkVersionTags enables linking version strings like v1.2.3 to their release pages. The prefix is
+// the part before the version numbers such as "v". Pre-release and build metadata suffixes like
+// v1.2.3-beta.1 are also linked. This is disabled by default since false positives are likely.
+func (l *Reflinker) LinkVersionTags(prefix string) {
+	l.version = regexp.MustCompile(regexp.QuoteMeta(prefix) + `\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?`)
+}
+
+func (l *Reflinker) reset(src []byte) {
+	l.src = src
+	l.orig = src
+	l.reps = nil
+	l.shortcuts = nil
+	l.inlineLinks = nil
+	l.ticks = nil
+}
+
+// start returns a copy of the linker which holds the state of a single call so that multiple calls
+// can run concurrently. The options are shared with the original linker.
+func (l *Reflinker) start(src []byte) *Reflinker {
+	c := *l
+	// goldmark does not skip UTF-8 BOM. For example, a URL just after BOM is not recognized.
+	src, c.bom = bytes.CutPrefix(src, utf8BOM)
+	c.reset(src)
+	if l.fullWidth {
+		c.src = maskFullWidthSigils(src)
+	}
+	return &c
+}
+
+var (
+	fullWidthHash = []byte("＃")
+	fullWidthAt   = []byte("＠")
+)
+
+// maskFullWidthSigils returns the text where full-width '＃' and '＠' are replaced with ASCII '#' and
+// '@' following fillers. The fillers keep the byte offsets same as the original text so that only
+// the linked ranges are normalized in the output.
+func maskFullWidthSigils(src []byte) []byte {
+	if !bytes.Contains(src, fullWidthHash) && !bytes.Contains(src, fullWidthAt) {
+		return src
+	}
+	masked := bytes.Clone(src)
+	for i := 0; i+len(fullWidthHash) <= len(masked); i++ {
+		var sigil byte
+		if bytes.HasPrefix(masked[i:], fullWidthHash) {
+			sigil = '#'
+		} else if bytes.HasPrefix(masked[i:], fullWidthAt) {
+			sigil = '@'
+		} else {
+			continue
+		}
+		masked[i], masked[i+1], masked[i+2] = fullWidthFiller, fullWidthFiller, sigil
+		i += 2
+	}
+	return masked
+}
+
+// fullWidthFiller is a boundary character which is not special in markdown.
+const fullWidthFiller = '\x01'
+
+// sigilStart returns the start offset of the sigil '#' or '@' at the offset in the original text. It
+// differs from the offset when the sigil was full-width.
+func (l *Reflinker) sigilStart(offset int) int {
+	if !l.fullWidth || offset < 2 || offset >= len(l.src) || l.src[offset-1] != fullWidthFiller || l.src[offset-2] != fullWidthFiller {
+		return offset
+	}
+	if o := l.orig[offset-2 : offset+1]; !bytes.Equal(o, fullWidthHash) && !bytes.Equal(o, fullWidthAt) {
+		return offset
+	}
+	return offset - 2
+}
+
+// isEscapedAt returns true when the character at the offset is escaped with a backslash like \#123.
+// Markdown only escapes ASCII punctuations so the backslash in \https://... is a literal character.
+// External references like \GH-123 are exceptions since their prefixes work as sigils.
+func (l *Reflinker) isEscapedAt(offset int, kind RefKind) bool {
+	b := l.src[offset]
+	if s := l.sigilStart(offset + 2); s != offset+2 {
+		b = l.src[offset+2] // Full-width sigil is escaped like the normalized one
+	}
+	if kind != RefExt && (b >= utf8.RuneSelf || !unicode.IsPunct(rune(b)) && !unicode.IsSymbol(rune(b))) {
+		return false
+	}
+	return l.isAfterBackslash(offset)
+}
+
+func (l *Reflinker) isAfterBackslash(offset int) bool {
+	n := 0
+	for i := offset - 1; i >= 0 && l.src[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1 // '\\#123' is an escaped backslash followed by #123
+}
+
+func (l *Reflinker) addReplacement(rep replacement) {
+	rep.start = l.sigilStart(rep.start) // Replace the whole full-width sigil like ＃123
+	if rep.start > 0 && l.src[rep.start-1] == ')' && l.isAfterRefLink(rep.start) {
+		// The ')' closing a link to a reference is not a boundary. Otherwise #1#2 would be linked to
+		// [#1](...)#2 at first and then [#1](...)[#2](...) when linking the output again.
+		slog.Debug("Skipped reference just after link to reference", "replacement", &rep)
+		l.skipped(l.orig[rep.start:rep.end], "preceded by the end of link to reference")
+		return
+	}
+	if l.isEscapedAt(rep.start, rep.kind) {
+		slog.Debug("Skipped reference escaped with backslash", "replacement", &rep)
+		l.skipped(l.orig[rep.start:rep.end], "escaped with backslash")
+		return
+	}
+	if l.validator != nil {
+		r := rep.ref(l.orig)
+		if !l.validator(r) {
+			slog.Debug("Reference was rejected by validator", "ref", r)
+			l.skipped(l.orig[rep.start:rep.end], "rejected by validator")
+			return
+		}
+	}
+	if l.transformURL != nil {
+		u := l.transformURL(rep.kind, rep.url)
+		slog.Debug("Transformed URL of reference", "kind", rep.kind, "from", rep.url, "to", u)
+		rep.url = u
+	}
+	if l.relative && (len(l.relativeKinds) == 0 || slices.Contains(l.relativeKinds, rep.kind)) {
+		if u := []byte(rep.url); l.isRepoURL(u) && len(u) > len(l.repo) {
+			rep.url = rep.url[len(l.repo)-len(l.repoPath()):] // e.g. https://github.com/o/r/issues/1 → /o/r/issues/1
+		}
+	}
+	rep.format = l.format
+	if n, _ := slices.BinarySearch(l.ticks, rep.start); n%2 == 1 && strings.ContainsRune(rep.label, '`') {
+		// The unpaired backtick before the link would be paired with the backtick in the label and
+		// the link would be broken like `[`41608e5f41`](...)
+		rep.label = strings.ReplaceAll(rep.label, "`", "")
+	}
+	if rep.url != "" && l.format == LinkFormatMarkdown {
+		if l.isShortcutEnd(rep.start) {
+			rep.prefix = "[]" // Convert [foo] into the collapsed reference link [foo][]
+		} else if l.isAfterBackslash(rep.start) {
+			rep.prefix = `\` // Escape the backslash which is not an escape in the input
+		}
+	}
+	l.reps = append(l.reps, rep)
+}
+
+// inlineLink is an inline link or image like [foo](https://example.com) followed by text.
+type inlineLink struct {
+	end   int    // End offset of the link, which is the start of the following text
+	label []byte // Text of the label without markups
+}
+
+// isAfterRefLink returns true when an inline link whose label is a single reference like [#1](...)
+// ends at the offset. The label would not be followed by a boundary before the link was generated.
+// Links to other texts like [see](...)#1 are not considered.
+func (l *Reflinker) isAfterRefLink(offset int) bool {
+	i, ok := slices.BinarySearchFunc(l.inlineLinks, offset, func(e inlineLink, t int) int { return e.end - t })
+	if !ok {
+		return false
+	}
+	label := l.inlineLinks[i].label
+	if len(label) == 0 {
+		return false
+	}
+
+	if len(label) >= 7 && !slices.ContainsFunc(label, func(b byte) bool { return !l.isHexChar(b) }) {
+		return true // Commit hashes are shortened in labels like [`41608e5f41`](...)
+	}
+
+	c := *l
+	c.noVerify = true
+	c.debugLog = nil
+	c.validator = nil
+	c.transformURL = nil
+	c.resolveIssue = nil
+	isRef := func(b []byte) bool {
+		v := c.linkAll(b)
+		return len(v.reps) == 1 && v.reps[0].start == 0 && v.reps[0].end == len(v.src)
+	}
+	if isRef(label) {
+		return true
+	}
+
+	// The owner is omitted in labels of references to repositories owned by the same owner like
+	// [repo#1](...). See slugLabelPrefix
+	if slices.Contains(label, '/') {
+		return false
+	}
+	owner, _, _ := strings.Cut(strings.TrimPrefix(l.repo, l.home+"/"), "/")
+	return isRef(append([]byte(owner+"/"), label...))
+}
+
+// isShortcutEnd returns true when a shortcut reference link recorded while walking the tree ends at
+// the offset. The offsets are recorded in increasing order.
+func (l *Reflinker) isShortcutEnd(offset int) bool {
+	_, ok := slices.BinarySearch(l.shortcuts, offset)
+	return ok
+}
+
+// isShortcutRefLinkEnd returns true when the link or image ending at the offset is a shortcut
+// reference link like [foo]. [foo][bar] and [foo][] are not.
+func (l *Reflinker) isShortcutRefLinkEnd(end int) bool {
+	if end < 2 || l.src[end-1] != ']' {
+		return false // e.g. [foo](https://example.com)
+	}
+	depth := 0
+	for i := end - 1; i >= 0; i-- {
+		switch l.src[i] {
+		case ']':
+			depth++
+		case '[':
+			depth--
+			if depth == 0 {
+				return i == 0 || l.src[i-1] != ']'
+			}
+		}
+	}
+	return false
+}
+
+// resourceURL returns the URL of the resource in the repository like https://github.com/o/r/issues/1.
+func (l *Reflinker) resourceURL(repo, resource string, id []byte) string {
+	if l.flavor == FlavorGitLab {
+		return fmt.Sprintf("%s/-/%s/%s", repo, resource, id)
+	}
+	return fmt.Sprintf("%s/%s/%s", repo, resource, id)
+}
+
+func (l *Reflinker) issueRefURL(num []byte) string {
+	num = l.issueNumber(num)
+	if l.issueURL == "" {
+		return l.resourceURL(l.repo, "issues", num)
+	}
+	return strings.NewReplacer("<repo>", l.repo, "<num>", string(num)).Replace(l.issueURL)
+}
+
+func (l *Reflinker) isBoundaryAt(idx int) bool {
+	if idx < 0 || len(l.src) <= idx {
+		return true
+	}
+	return isBoundary(l.src[idx])
+}
+
+func (l *Reflinker) lastIndexIssueRef(offset, start, end int) int {
+	if offset+1 >= end {
+		return -1 // The text ends with '#'
+	}
+	if p := l.sigilStart(offset); !l.looseIssue && start < p && !l.isBoundaryAt(p-1) {
+		l.skipped(l.tokenAt(offset, end), "preceded by non-boundary %q", l.src[p-1])
+		return -1 // Issue ref must follow a boundary (e.g. 'foo#bar')
+	}
+
+	for i := 1; offset+i < end; i++ {
+		b := l.src[offset+i]
+		if '0' <= b && b <= '9' {
+			continue
+		}
+		if i == 1 {
+			return -1
+		}
+		if !isBoundary(b) {
+			l.skipped(l.tokenAt(offset, end), "followed by non-boundary %q", b)
+			return -1
+		}
+		if b == ';' && start < offset && l.src[offset-1] == '&' {
+			return -1 // Numeric character reference like '&#35;'
+		}
+		return l.checkIssueNumber(offset, offset+i)
+	}
+
+	return l.checkIssueNumber(offset, end) // The text ends with issue number
+}
+
+// checkIssueNumber returns the end offset of the issue reference or -1 when the issue number is
+// rejected by SetLeadingZeros.
+func (l *Reflinker) checkIssueNumber(offset, end int) int {
+	if l.leadingZeros == LeadingZerosReject && l.src[offset+1] == '0' {
+		slog.Debug("Skipped issue number which starts with zero", "ref", l.src[offset:end])
+		l.skipped(l.src[offset:end], "issue number starts with zero")
+		return -1 // e.g. #0, #007
+	}
+	return end
+}
+
+// issueNumber returns the issue number in the link target.
+func (l *Reflinker) issueNumber(num []byte) []byte {
+	if l.leadingZeros != LeadingZerosStrip {
+		return num
+	}
+	for len(num) > 1 && num[0] == '0' {
+		num = num[1:] // e.g. 007 → 7 while 0 is kept
+	}
+	return num
+}
+
+func (l *Reflinker) linkIssueRef(offset, start, end int) int {
+	if e := l.linkSlugIssueRef(offset, start, end); e >= 0 {
+		return e
+	}
+
+	e := l.lastIndexIssueRef(offset, start, end)
+	if e < 0 {
+		return offset + 1
+	}
+
+	r := l.src[offset:e]
+	n, err := strconv.Atoi(string(r[1:]))
+	if err == nil {
+		if _, ok := l.deniedIssues[n]; ok {
+			slog.Debug("Skipped issue reference in denylist", "issue", r)
+			l.skipped(r, "issue number is in denylist")
+			return e
+		}
+	}
+
+	rep := replacement{
+		start: offset,
+		end:   e,
+		kind:  RefIssue,
+		label: string(r),
+		// Note: The link may be for PR, but GitHub can redirect this issue link to the PR
+		url: l.issueRefURL(r[1:]),
+	}
+	if err == nil {
+		rep.issue = n
+		l.resolvePullRequest(&rep, r[1:])
+	}
+	slog.Debug("Found issue reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
+	l.addReplacement(rep)
+
+	return e
+}
+
+// resolvePullRequest links the issue reference in the repository to the pull request page when the
+// resolver set by SetIssuePRResolver resolves its number rep.issue as a pull request.
+func (l *Reflinker) resolvePullRequest(rep *replacement, num []byte) {
+	if l.resolveIssue == nil || l.resolveIssue(rep.issue) != IssueKindPullRequest {
+		return
+	}
+	resource := "pull"
+	if l.flavor == FlavorGitLab {
+		resource = "merge_requests"
+	}
+	rep.label = "PR " + rep.label
+	rep.url = l.resourceURL(l.repo, resource, l.issueNumber(num))
+}
+
+// linkGitLabRef links GitLab-specific references which consist of a sigil and a number like !123.
+func (l *Reflinker) linkGitLabRef(offset, start, end int, kind RefKind, resource string) int {
+	e := l.lastIndexIssueRef(offset, start, end)
+	if e < 0 {
+		return offset + 1
+	}
+
+	r := l.src[offset:e]
+	rep := replacement{
+		start: offset,
+		end:   e,
+		kind:  kind,
+		label: string(r),
+		url:   l.resourceURL(l.repo, resource, l.issueNumber(r[1:])),
+	}
+	slog.Debug("Found GitLab reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
+	l.addReplacement(rep)
+
+	return e
+}
+
+func (l *Reflinker) lastIndexUserRef(offset, start, end int) int {
+	if offset+1 >= end {
+		return -1 // The text ends with '@'
+	}
+	p := l.sigilStart(offset)
+	if start < p && !l.isBoundaryAt(p-1) {
+		l.skipped(l.tokenAt(offset, end), "preceded by non-boundary %q", l.src[p-1])
+		return -1 // e.g. foo@bar, _@foo (-@foo is ok)
+	}
+	if l.mentionSpace && p > 0 && !isSpace(l.src[p-1]) {
+		l.skipped(l.tokenAt(offset, end), "not preceded by whitespace")
+		return -1 // e.g. (@foo), -@foo
+	}
+	if l.flavor == FlavorGitLab {
+		return l.lastIndexGitLabUserRef(offset, end)
+	}
+
+	// Note: Username may only contain alphanumeric characters or single hyphens, and cannot begin
+	// or end with a hyphen: @foo-, @-foo, @foo--bar
+	// Note: '/' just after user name like @foo/ is not allowed
+
+	if b := l.src[offset+1]; !isUserNameChar(b) || b == '-' {
+		return -1
+	}
+
+	for i := 2; offset+i < end; i++ {
+		b := l.src[offset+i]
+		if b == '-' && l.src[offset+i-1] == '-' {
+			return -1 // Consecutive hyphens
+		}
+		if isUserNameChar(b) {
+			continue
+		}
+		if !isBoundary(b) || b == '/' || l.src[offset+i-1] == '-' {
+			return -1
+		}
+		if b >= utf8.RuneSelf && isLatinLetterOrMark(l.src[offset+i:end]) {
+			return -1 // e.g. @café, @cafe\u0301
+		}
+		return offset + i
+	}
+
+	if l.src[end-1] == '-' {
+		return -1
+	}
+
+	return end
+}
+
+// lastIndexGitLabUserRef returns the end offset of GitLab user or group mention. On GitLab, usernames
+// and group paths may contain '_' and '.' in addition, and subgroups are mentioned with their full
+// paths like @group/subgroup. Users and groups are linked to their pages like
+// https://gitlab.com/group/subgroup.
+// https://docs.gitlab.com/ee/user/group/subgroups/#mention-subgroups
+func (l *Reflinker) lastIndexGitLabUserRef(offset, end int) int {
+	isStart := func(b byte) bool {
+		return isUserNameChar(b) && b != '-' || b == '_'
+	}
+	if !isStart(l.src[offset+1]) {
+		return -1
+	}
+
+	e := offset + 2
+	for e < end {
+		b := l.src[e]
+		if isRepoNameChar(b) || b == '/' && e+1 < end && isStart(l.src[e+1]) {
+			e++
+			continue
+		}
+		if b >= utf8.RuneSelf && isLatinLetterOrMark(l.src[e:end]) {
+			return -1 // e.g. @café
+		}
+		break
+	}
+
+	for l.src[e-1] == '.' {
+		e-- // Path cannot end with '.' like "Thanks @foo."
+	}
+	return e
+}
+
+// hasMentionTrigger returns true when any trigger phrase set by SetMentionTriggers appears before the
+// offset in the same line.
+func (l *Reflinker) hasMentionTrigger(offset int) bool {
+	line := bytes.ToLower(l.src[bytes.LastIndexByte(l.src[:offset], '\n')+1 : offset])
+	for _, p := range l.mentionTrigs {
+		for i := 0; i < len(line); {
+			j := bytes.Index(line[i:], p)
+			if j < 0 {
+				break
+			}
+			s, e := i+j, i+j+len(p)
+			if (s == 0 || isBoundary(line[s-1])) && (e == len(line) || isBoundary(line[e])) {
+				return true
+			}
+			i = s + 1
+		}
+	}
+	return false
+}
+
+func (l *Reflinker) userRefURL(user []byte) string {
+	if l.userURL == "" {
+		return fmt.Sprintf("%s/%s", l.home, user)
+	}
+	return strings.NewReplacer("<home>", l.home, "<user>", string(user)).Replace(l.userURL)
+}
+
+func (l *Reflinker) linkUserRef(offset, start, end int) int {
+	e := l.lastIndexUserRef(offset, start, end)
+	if e < 0 {
+		return offset + 1
+	}
+
+	u := l.src[offset:e]
+	if l.flavor == FlavorGitHub && l.maxUserLen > 0 && len(u)-1 > l.maxUserLen {
+		slog.Debug("Skipped user reference with too long user name", "user", u, "max", l.maxUserLen)
+		l.skipped(u, "user name is longer than %d characters", l.maxUserLen)
+		return e
+	}
+	if len(l.mentionTrigs) > 0 && !l.hasMentionTrigger(offset) {
+		slog.Debug("Skipped user reference without trigger phrase before it", "user", u)
+		l.skipped(u, "no trigger phrase before it")
+		return e
+	}
+	if l.users != nil {
+		if _, ok := l.users[strings.ToLower(string(u[1:]))]; !ok {
+			slog.Debug("Skipped user reference not in allowlist", "user", u)
+			l.skipped(u, "user is not in allowlist")
+			return e
+		}
+	}
+
+	rep := replacement{
+		start: offset,
+		end:   e,
+		kind:  RefUser,
+		label: string(u),
+		url:   l.userRefURL(u[1:]),
+	}
+	slog.Debug("Found user reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
+	l.addReplacement(rep)
+
+	return e
+}
+
+const hashLen int = 40
+
+// abbrevHash returns the abbreviated commit hash for display.
+func (l *Reflinker) abbrevHash(h []byte) []byte {
+	if l.fullSHA {
+		return h
+	}
+	n := l.shaLen
+	if n <= 0 {
+		n = 10
+	}
+	if len(h) > n {
+		return h[:n]
+	}
+	return h
+}
+
+func (l *Reflinker) isHexChar(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || l.upperSHA && 'A' <= b && b <= 'F'
+}
+
+// normalizeHash returns the lowercase commit hash since uppercase characters are accepted only when
+// SetAcceptUppercaseSHA is enabled.
+func (l *Reflinker) normalizeHash(h []byte) []byte {
+	if !l.upperSHA {
+		return h
+	}
+	return bytes.ToLower(h)
+}
+
+func (l *Reflinker) linkCommitSHA(offset, start, end int) int {
+	for i := 1; i < hashLen; i++ { // Since l.src[offset] was already checked, i starts from 1
+		if offset+i >= end {
+			return offset + i
+		}
+		if l.isHexChar(l.src[offset+i]) {
+			continue
+		}
+		return offset + i
+	}
+
+	hashEnd := offset + hashLen
+	if (start == offset || l.isBoundaryAt(offset-1)) && (hashEnd == end || l.isBoundaryAt(hashEnd)) {
+		h := l.normalizeHash(l.src[offset:hashEnd])
+		rep := replacement{
+			start: offset,
+			end:   offset + hashLen,
+			kind:  RefCommit,
+			label: fmt.Sprintf("`%s`", l.abbrevHash(h)),
+			url:   l.resourceURL(l.repo, "commit", h),
+		}
+		slog.Debug("Found commit hash reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
+		l.addReplacement(rep)
+	}
+
+	return offset + hashLen
+}
+
+// firstIndexSlug returns the start offset of 'owner/repo' slug which ends just before the offset.
+// It returns -1 when no slug precedes the offset.
+func (l *Reflinker) firstIndexSlug(offset, start int) int {
+	slash := -1
+	e := l.sigilStart(offset)
+	i := e - 1
+	for ; i >= start; i-- {
+		b := l.src[i]
+		if b == '/' {
+			if slash >= 0 {
+				return -1 // e.g. foo/bar/piyo@...
+			}
+			slash = i
+			continue
+		}
+		if !isRepoNameChar(b) {
+			break
+		}
+	}
+
+	s := i + 1
+	if slash < 0 {
+		return -1 // e.g. foo@...
+	}
+	if i >= start && (l.src[i] == '#' || l.src[i] == '@') {
+		return -1 // e.g. #1/foo#..., @foo/bar@...
+	}
+	if !isValidSlug(l.src[s:slash], l.src[slash+1:e]) {
+		return -1 // e.g. /foo@..., foo/@..., ../foo@...
+	}
+
+	return s
+}
+
+// slugLabelPrefix returns the prefix of the label of the reference to the repository slug. Like
+// GitHub, the slug is omitted for the same repository and the owner is omitted for the repository
+// owned by the same owner.
+func (l *Reflinker) slugLabelPrefix(slug string) string {
+	own := strings.TrimPrefix(l.repo, l.home+"/")
+	if strings.EqualFold(slug, own) {
+		return ""
+	}
+	owner, name, _ := strings.Cut(slug, "/")
+	if o, _, _ := strings.Cut(own, "/"); strings.EqualFold(owner, o) {
+		return name
+	}
+	return slug
+}
+
+// linkSlugIssueRef links the issue reference in other repository like 'owner/repo#123'. It returns
+// -1 when the text at the offset is not an issue reference with repository slug.
+func (l *Reflinker) linkSlugIssueRef(offset, start, end int) int {
+	s := l.firstIndexSlug(offset, start)
+	if s < 0 {
+		return -1
+	}
+	e := l.lastIndexIssueRef(offset, offset, end) // The slug is a boundary before the '#'
+	if e < 0 {
+		return -1
+	}
+
+	slug, num := string(l.src[s:l.sigilStart(offset)]), l.src[offset+1:e]
+	rep := replacement{
+		start: s,
+		end:   e,
+		kind:  RefIssue,
+		label: fmt.Sprintf("%s#%s", l.slugLabelPrefix(slug), num),
+		url:   l.resourceURL(l.home+"/"+slug, "issues", l.issueNumber(num)),
+	}
+	if strings.EqualFold(l.home+"/"+slug, l.repo) {
+		if n, err := strconv.Atoi(string(num)); err == nil {
+			rep.issue = n
+			l.resolvePullRequest(&rep, num)
+		}
+	}
+	slog.Debug("Found issue reference autolink with repository slug", "replacement", &rep, "offset", offset, "start", start, "end", end)
+	l.addReplacement(rep)
+
+	return e
+}
+
+// linkSlugCommitRef links the commit reference in other repository like 'owner/repo@abcdef0'. It
+// returns -1 when the text at the offset is not a commit reference.
+func (l *Reflinker) linkSlugCommitRef(offset, start, end int) int {
+	s := l.firstIndexSlug(offset, start)
+	if s < 0 {
+		return -1
+	}
+
+	e := offset + 1
+	for e < end && e-offset-1 < hashLen {
+		if !l.isHexChar(l.src[e]) {
+			break
+		}
+		e++
+	}
+	if n := e - offset - 1; n < 7 || !l.isBoundaryAt(e) {
+		return -1 // Short hash must be at least 7 characters
+	}
+
+	slug, hash := string(l.src[s:l.sigilStart(offset)]), l.normalizeHash(l.src[offset+1:e])
+	short := l.abbrevHash(hash)
+
+	var label string
+	if p := l.slugLabelPrefix(slug); p == "" {
+		label = fmt.Sprintf("`%s`", short)
+	} else {
+		label = fmt.Sprintf("%s@`%s`", p, short)
+	}
+
+	rep := replacement{
+		start: s,
+		end:   e,
+		kind:  RefCommit,
+		label: label,
+		url:   l.resourceURL(l.home+"/"+slug, "commit", hash),
+	}
+	slog.Debug("Found commit reference autolink with repository slug", "replacement", &rep, "offset", offset, "start", start, "end", end)
+	l.addReplacement(rep)
+
+	return e
+}
+
+func (l *Reflinker) linkAtRef(offset, start, end int) int {
+	// '@' after 'owner/repo' is a separator of commit reference. Otherwise it is a user reference.
+	if e := l.linkSlugCommitRef(offset, start, end); e >= 0 {
+		return e
+	}
+	return l.linkUserRef(offset, start, end)
+}
+
+func (l *Reflinker) linkGitHubRefs(start, stop int) {
+	o := start
+	triggers := l.flavor.refTriggers()
+	if l.upperSHA {
+		triggers += "ABCDEF"
+	}
+
+	for o < stop-1 { // `-1` means the last character is not checked
+		s := l.src[o:stop]
+		i := bytes.IndexAny(s, triggers)
+		if i < 0 || len(s)-1 <= i {
+			return
+		}
+
+		switch s[i] {
+		case '#':
+			o = l.linkIssueRef(o+i, start, stop)
+		case '@':
+			o = l.linkAtRef(o+i, start, stop)
+		case '!':
+			o = l.linkGitLabRef(o+i, start, stop, RefMergeRequest, "merge_requests")
+		case '%':
+			o = l.linkGitLabRef(o+i, start, stop, RefMilestone, "milestones")
+		default:
+			// hex character [0-9a-f] (or [A-F] when uppercase is accepted)
+			o = l.linkCommitSHA(o+i, start, stop)
+		}
+	}
+}
+
+// AddExtRef adds external refeerence. Parameters are corresponding to the API:
+// https://docs.github.com/en/rest/repos/autolinks?apiVersion=2022-11-28
+func (l *Reflinker) AddExtRef(prefix, url string, alphanumeric bool) {
+	l.AddExtRefOpts(prefix, url, ExtRefOpts{Alphanumeric: alphanumeric, RequireBoundary: true})
+}
+
+// AddExtRefOpts adds external reference with the options. AddExtRef is the same as this method with
+// RequireBoundary option enabled.
+func (l *Reflinker) AddExtRefOpts(prefix, url string, opts ExtRefOpts) {
+	pat := regexp.QuoteMeta(prefix)
+	if opts.RequireBoundary && prefix != "" {
+		if !isBoundary(prefix[0]) {
+			pat = `\b` + pat
+		} else {
+			pat = `\B` + pat // \b before non-word character like # requires a word character before it
+		}
+	}
+	if opts.Alphanumeric {
+		pat += `[a-zA-Z0-9_]+`
+	} else {
+		pat += `\d+\b`
+	}
+
+	l.ext = append(l.ext, extRef{prefix, regexp.MustCompile(pat), url})
+}
+
+// AddRegexpRef adds a reference matched by the regular expression. The URL is expanded with the
+// submatches of the pattern like regexp.Regexp.Expand, e.g. "https://nvd.nist.gov/vuln/detail/$0".
+// These references take precedence over all other references. Texts matched by them are not
+// detected as other references. When multiple patterns match at the same position, the pattern
+// added first is used.
+func (l *Reflinker) AddRegexpRef(pat *regexp.Regexp, url string) {
+	l.regexps = append(l.regexps, regexpRef{pat, url})
+}
+
+// linkRegexpRef links the earliest reference matched by the regular expressions and returns the
+// range of it. It returns (end, end) when no reference is found.
+func (l *Reflinker) linkRegexpRef(start, end int) (int, int) {
+	src := l.src[start:end]
+	var found *regexpRef
+	var m []int
+	for i := range l.regexps {
+		r := l.regexps[i].pat.FindSubmatchIndex(src)
+		if r == nil || r[0] == r[1] {
+			continue // Empty match is never linked
+		}
+		if found == nil || r[0] < m[0] {
+			found, m = &l.regexps[i], r
+		}
+	}
+	if found == nil {
+		return end, end
+	}
+
+	url := found.pat.Expand(nil, []byte(found.url), src, m)
+	rep := replacement{
+		start: start + m[0],
+		end:   start + m[1],
+		kind:  RefRegexp,
+		label: string(src[m[0]:m[1]]),
+		url:   string(url),
+	}
+	slog.Debug("Found regular expression reference autolink", "replacement", &rep, "start", start, "end", end)
+	l.addReplacement(rep)
+	return start + m[0], start + m[1]
+}
+
+func (l *Reflinker) linkExtRef(start, end int) int {
+	// Find the earliest match among all external references
+	src := l.src[start:end]
+	var found *extRef
+	var s, e int
+	for i := range l.ext {
+		if r := l.ext[i].pat.FindIndex(src); r != nil && (found == nil || r[0] < s) {
+			found, s, e = &l.ext[i], r[0], r[1]
+		}
+	}
+	if found == nil {
+		return end // Not found
+	}
+
+	ref := src[s:e]
+	num := ref[len(found.prefix):]
+	url := strings.ReplaceAll(found.url, "<num>", string(num))
+	rep := replacement{
+		start: start + s,
+		end:   start + e,
+		kind:  RefExt,
+		label: string(ref),
+		url:   url,
+	}
+	if p, ok := strings.CutPrefix(url, l.repo+"/issues/"); ok {
+		if n, err := strconv.Atoi(p); err == nil {
+			rep.issue = n // e.g. GH-123
+			l.resolvePullRequest(&rep, []byte(p))
+		}
+	}
+	slog.Debug("Found external resource (custom) autolink", "replacement", &rep, "start", start, "end", end)
+	l.addReplacement(rep)
+	return start + e
+}
+
+func (l *Reflinker) linkExtRefs(start, stop int) {
+	o := start
+	for o < stop-1 {
+		o = l.linkExtRef(o, stop)
+	}
+}
+
+func (l *Reflinker) linkVersionRefs(start, end int) {
+	for _, r := range l.version.FindAllIndex(l.src[start:end], -1) {
+		s, e := start+r[0], start+r[1]
+		for e > s && (l.src[e-1] == '.' || l.src[e-1] == '-') {
+			e-- // Exclude trailing punctuation like "released v1.2.3."
+		}
+		if s > start && !l.isBoundaryAt(s-1) {
+			continue // e.g. rev1.2.3
+		}
+		if e < end && (!l.isBoundaryAt(e) || l.src[e] == '.' && !l.isBoundaryAt(e+1)) {
+			continue // e.g. v1.2.3a, v1.2.3.4
+		}
+
+		v := l.src[s:e]
+		resource := "releases/tag"
+		if l.flavor == FlavorGitLab {
+			resource = "releases"
+		}
+		rep := replacement{
+			start: s,
+			end:   e,
+			kind:  RefVersion,
+			label: string(v),
+			url:   l.resourceURL(l.repo, resource, v),
+		}
+		slog.Debug("Found version tag autolink", "replacement", &rep, "start", start, "end", end)
+		l.addReplacement(rep)
+	}
+}
+
+// Commit URL with fragment should not be converted to a reference link.
+// e.g. https://github.com/rhysd/changelog-from-release/commit/096c8152092281371e88265dd43b1b7d23a88453#diff-ced928ba39db1f56ef7862baebfe0314ed06f433a71defdc60a2b12e67011453L226
+var reGitHubCommitPath = regexp.MustCompile(`^/([^/]+/[^/]+)/commit/([[:xdigit:]]{7,})/?$`)
+
+func (l *Reflinker) linkCommitURL(m [][]byte, url []byte, start, end int) {
+	slug, hash := m[1], l.abbrevHash(m[2])
+
+	var label string
+	if l.isRepoURL(url) {
+		label = fmt.Sprintf("`%s`", hash)
+	} else {
+		label = fmt.Sprintf("%s@`%s`", slug, hash)
+	}
+
+	rep := replacement{
+		start: start,
+		end:   end,
+		kind:  RefCommitURL,
+		label: label,
+		url:   string(url),
+	}
+	slog.Debug("Converted commit URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
+	l.addReplacement(rep)
+}
+
+// Consider URL with fragment which links to issue comments.
+// e.g.
+// - https://github.com/rhysd/changelog-from-release/issues/11#issue-1327166917
+// - https://github.com/rhysd/changelog-from-release/issues/11#issuecomment-1346614286
+// - https://github.com/rhysd/changelog-from-release/pull/15#pullrequestreview-1212591132
+// - https://github.com/rhysd/changelog-from-release/pull/15#discussion_r1045110870
+// Sub-pages of pull requests are also considered.
+// e.g. https://github.com/rhysd/changelog-from-release/pull/15/files
+var reGitHubIssuePath = regexp.MustCompile(`^/([^/]+/[^/]+)/(pull|issues)/(\d+)(?:/(files|commits|checks))?/?(#.+)?$`)
+
+func (l *Reflinker) linkIssueURL(m [][]byte, url []byte, start, end int) {
+	slug, resource, num, sub, frag := m[1], m[2], m[3], m[4], m[5]
+
+	var note string
+	if len(sub) > 0 {
+		if string(resource) != "pull" {
+			return // Issues don't have sub-pages
+		}
+		note = fmt.Sprintf(" (%s)", sub)
+	} else if len(frag) > 0 {
+		// When hash like #issue-12345 follows, it links to a comment in the issue thread
+		if bytes.HasPrefix(frag, []byte("#pullrequestreview-")) {
+			note = l.reviewNote
+		} else {
+			note = l.commentNote
+		}
+	}
+
+	var label string
+	if l.isRepoURL(url) {
+		label = fmt.Sprintf("#%s%s", num, note)
+	} else {
+		label = fmt.Sprintf("%s#%s%s", slug, num, note)
+	}
+
+	rep := replacement{
+		start: start,
+		end:   end,
+		kind:  RefIssueURL,
+		label: label,
+		url:   string(url),
+	}
+	if l.isRepoURL(url) {
+		rep.issue, _ = strconv.Atoi(string(num))
+	}
+	slog.Debug("Converted issue/PR URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
+	l.addReplacement(rep)
+}
+
+// repoPath returns the path of the repository URL like /owner/repo.
+func (l *Reflinker) repoPath() string {
+	if i := strings.Index(l.repo, "://"); i >= 0 {
+		if j := strings.IndexByte(l.repo[i+3:], '/'); j >= 0 {
+			return l.repo[i+3+j:]
+		}
+	}
+	return ""
+}
+
+// isRepoURL returns true when the URL is in the repository. Note that https://github.com/o/r2 is not
+// in the repository https://github.com/o/r. The owner and the repository names are compared
+// case-insensitively since GitHub treats https://github.com/O/R as the same repository.
+func (l *Reflinker) isRepoURL(url []byte) bool {
+	if len(url) < len(l.repo) || !bytes.EqualFold(url[:len(l.repo)], []byte(l.repo)) {
+		return false
+	}
+	p := url[len(l.repo):]
+	return len(p) == 0 || p[0] == '/'
+}
+
+// e.g. https://github.com/rhysd/changelog-from-release/releases/tag/v3.7.0
+var reGitHubReleasePath = regexp.MustCompile(`^/([^/]+/[^/]+)/releases/tag/([^/]+)/?$`)
+
+// e.g. https://github.com/rhysd/changelog-from-release/compare/v3.6.0...v3.7.0
+var reGitHubComparePath = regexp.MustCompile(`^/([^/]+/[^/]+)/compare/([^/]+?)(\.{2,3})([^/.][^/]*)/?$`)
+
+func (l *Reflinker) abbrevTag(tag []byte) string {
+	if l.maxTagLen <= 0 || utf8.RuneCount(tag) <= l.maxTagLen {
+		return string(tag)
+	}
+	i := 0
+	for n := 0; n < l.maxTagLen; n++ {
+		_, s := utf8.DecodeRune(tag[i:])
+		i += s
+	}
+	return string(tag[:i]) + "…"
+}
+
+func (l *Reflinker) linkReleaseURL(m [][]byte, url []byte, start, end int) {
+	slug, tag := m[1], l.abbrevTag(m[2])
+
+	var label string
+	if l.isRepoURL(url) {
+		label = tag
+	} else {
+		label = fmt.Sprintf("%s@%s", slug, tag)
+	}
+
+	rep := replacement{
+		start: start,
+		end:   end,
+		kind:  RefReleaseURL,
+		label: label,
+		url:   string(url),
+	}
+	slog.Debug("Converted release URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
+	l.addReplacement(rep)
+}
+
+func (l *Reflinker) linkCompareURL(m [][]byte, url []byte, start, end int) {
+	slug, base, dots, head := m[1], l.abbrevTag(m[2]), m[3], l.abbrevTag(m[4])
+
+	var label string
+	if l.compareLabel != "" {
+		label = l.compareLabel
+	} else if l.isRepoURL(url) {
+		label = fmt.Sprintf("%s%s%s", base, dots, head)
+	} else {
+		label = fmt.Sprintf("%s@%s%s%s", slug, base, dots, head)
+	}
+
+	rep := replacement{
+		start: start,
+		end:   end,
+		kind:  RefCompareURL,
+		label: label,
+		url:   string(url),
+	}
+	slog.Debug("Converted compare URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
+	l.addReplacement(rep)
+}
+
+// Only permalinks to commits are linked since file paths at branches are likely to be moved. Only
+// line anchors are allowed in the fragment.
+// e.g. https://github.com/rhysd/changelog-from-release/blob/096c8152092281371e88265dd43b1b7d23a88453/reflink.go#L10-L20
+var reGitHubBlobPath = regexp.MustCompile(`^/([^/]+/[^/]+)/blob/[[:xdigit:]]{7,40}/([^#]*[^/#])(#L\d+(?:-L\d+)?)?$`)
+
+func (l *Reflinker) linkBlobURL(m [][]byte, url []byte, start, end int) {
+	slug, path, line := m[1], m[2], m[3]
+
+	var label string
+	if l.isRepoURL(url) {
+		label = fmt.Sprintf("%s%s", path, line)
+	} else {
+		label = fmt.Sprintf("%s %s%s", slug, path, line)
+	}
+
+	rep := replacement{
+		start: start,
+		end:   end,
+		kind:  RefBlobURL,
+		label: label,
+		url:   string(url),
+	}
+	slog.Debug("Converted permalink URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
+	l.addReplacement(rep)
+}
+
+// GHSA IDs consist of three sets of four characters in the restricted alphabet.
+// e.g. https://github.com/rhysd/actionlint/security/advisories/GHSA-2jx2-76rc-2v7v
+// https://docs.github.com/en/code-security/security-advisories/working-with-global-security-advisories-from-the-github-advisory-database/about-the-github-advisory-database#about-ghsa-ids
+var reGitHubAdvisoryPath = regexp.MustCompile(`^/([^/]+/[^/]+)/security/advisories/(GHSA(?:-[23456789cfghjmpqrvwx]{4}){3})/?$`)
+
+func (l *Reflinker) linkAdvisoryURL(m [][]byte, url []byte, start, end int) {
+	slug, id := m[1], m[2]
+
+	var label string
+	if l.isRepoURL(url) {
+		label = string(id)
+	} else {
+		label = fmt.Sprintf("%s %s", slug, id)
+	}
+
+	rep := replacement{
+		start: start,
+		end:   end,
+		kind:  RefAdvisoryURL,
+		label: label,
+		url:   string(url),
+	}
+	slog.Debug("Converted security advisory URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
+	l.addReplacement(rep)
+}
+
+// urlPath returns the path of the URL when the URL is on the same host as the repository. When
+// linking URLs on foreign hosts is enabled, it also returns the path and the host of the URL on
+// other host. It returns nil path when the URL should not be linked.
+func (l *Reflinker) urlPath(url []byte) (path, host []byte) {
+	if p, ok := bytes.CutPrefix(url, []byte(l.home)); ok && (len(p) == 0 || p[0] == '/') {
+		return p, nil
+	}
+
+	if !l.foreignHosts {
+		return nil, nil
+	}
+
+	u, ok := bytes.CutPrefix(url, []byte("https://"))
+	if !ok {
+		u, ok = bytes.CutPrefix(url, []byte("http://"))
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	i := bytes.IndexByte(u, '/')
+	if i <= 0 {
+		return nil, nil
+	}
+	return u[i:], u[:i]
+}
+
+// searchStart returns the offset to start searching the inline node in the source. It is the end of
+// the text before the node (or before its inline ancestors like **...**) or the start of the block
+// containing the node. It returns -1 when the node follows a non-text node.
+func searchStart(n ast.Node) int {
+	if p := n.PreviousSibling(); p != nil {
+		t, ok := p.(*ast.Text)
+		if !ok {
+			return -1 // e.g. `code`https://..., **bold**https://...
+		}
+		return t.Segment.Stop
+	}
+
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if p.Type() == ast.TypeBlock {
+			if lines := p.Lines(); lines.Len() > 0 {
+				return lines.At(0).Start
+			}
+			return 0
+		}
+		if t, ok := p.PreviousSibling().(*ast.Text); ok {
+			return t.Segment.Stop
+		}
+	}
+	return 0
+}
+
+// trimURLSuffix removes the trailing punctuations and unbalanced ')' from the URL as GFM specifies.
+// goldmark trims them only once so a URL in "(see https://...)." includes the trailing ')'. Trailing
+// whitespaces are also removed in case the segment of the URL includes them.
+func trimURLSuffix(url []byte) []byte {
+	for len(url) > 0 {
+		switch url[len(url)-1] {
+		case '?', '!', '.', ',', ':', '*', '_', '~', ' ', '\t', '\r', '\n':
+			url = url[:len(url)-1]
+		case ')':
+			if bytes.Count(url, []byte{'('}) >= bytes.Count(url, []byte{')'}) {
+				return url
+			}
+			url = url[:len(url)-1]
+		default:
+			return url
+		}
+	}
+	return url
+}
+
+func (l *Reflinker) linkURL(n *ast.AutoLink) {
+	start := searchStart(n)
+	if start < 0 {
+		return
+	}
+
+	url := trimURLSuffix(n.URL(l.src))
+	if bytes.Count(url, []byte{'('}) != bytes.Count(url, []byte{')'}) {
+		return // Unbalanced parentheses would break the link destination like [...](https://...#()
+	}
+	path, host := l.urlPath(url)
+	if len(path) == 0 {
+		return
+	}
+	owner, rest, _ := bytes.Cut(path[1:], []byte{'/'})
+	if name, _, _ := bytes.Cut(rest, []byte{'/'}); !isURLSlug(owner, name) {
+		return // e.g. https://github.com/a/../issues/1
+	}
+
+	// Search the offset of the start of the URL. When the text is a child of some other node, URL
+	// may not appear just after the previous node. The example is **https://...** where URL appears
+	// after the first **.
+	offset := bytes.Index(l.src[start:], url)
+	if offset < 0 {
+		return
+	}
+	start += offset
+
+	end := start + len(url)
+	if start >= len(l.src) || end > len(l.src) {
+		return
+	}
+
+	// Note: `end` is the index of the character just after the URL
+	if start > 0 && l.src[start-1] == '<' && end < len(l.src) && l.src[end] == '>' {
+		return
+	}
+
+	if m := reGitHubCommitPath.FindSubmatch(path); m != nil {
+		if host != nil {
+			m[1] = fmt.Appendf(nil, "%s/%s", host, m[1])
+		}
+		l.linkCommitURL(m, url, start, end)
+	} else if m := reGitHubIssuePath.FindSubmatch(path); m != nil {
+		if host != nil {
+			m[1] = fmt.Appendf(nil, "%s/%s", host, m[1])
+		}
+		l.linkIssueURL(m, url, start, end)
+	} else if m := reGitHubReleasePath.FindSubmatch(path); m != nil {
+		if host != nil {
+			m[1] = fmt.Appendf(nil, "%s/%s", host, m[1])
+		}
+		l.linkReleaseURL(m, url, start, end)
+	} else if m := reGitHubComparePath.FindSubmatch(path); m != nil {
+		if host != nil {
+			m[1] = fmt.Appendf(nil, "%s/%s", host, m[1])
+		}
+		l.linkCompareURL(m, url, start, end)
+	} else if m := reGitHubBlobPath.FindSubmatch(path); m != nil {
+		if host != nil {
+			m[1] = fmt.Appendf(nil, "%s/%s", host, m[1])
+		}
+		l.linkBlobURL(m, url, start, end)
+	} else if m := reGitHubAdvisoryPath.FindSubmatch(path); m != nil {
+		if host != nil {
+			m[1] = fmt.Appendf(nil, "%s/%s", host, m[1])
+		}
+		l.linkAdvisoryURL(m, url, start, end)
+	}
+}
+
+// removeOverlappedReplacements removes the replacements whose ranges overlap with others since built-in
+// references and external references are detected independently in the same text. For example, @JIRA-1
+// is detected as both a user reference and an external reference JIRA-1.
+func (l *Reflinker) removeOverlappedReplacements() {
+	// Stable sort keeps built-in references before external references at the same position since
+	// they are detected first
+	sort.Stable(byStartOffset(l.reps))
+
+	reps := l.reps[:0]
+	for _, r := range l.reps {
+		if len(reps) == 0 || reps[len(reps)-1].end <= r.start {
+			reps = append(reps, r)
+			continue
+		}
+		last := &reps[len(reps)-1]
+		if l.extPriority && r.kind == RefExt && last.kind != RefExt {
+			slog.Debug("Replaced overlapped reference with external reference", "replaced", last, "replacement", &r)
+			*last = r
+		} else {
+			slog.Debug("Skipped reference overlapping with other reference", "replacement", &r, "other", last)
+			l.skipped(l.orig[r.start:r.end], "overlapping with %s", l.orig[last.start:last.end])
+		}
+	}
+	l.reps = reps
+}
+
+// isIssueRangeItem returns true when the replacement can be an item of an issue range. Only bare
+// issue references like #123 are collapsed. Other forms like u/r#123 are kept since the range label
+// would lose their texts.
+func (l *Reflinker) isIssueRangeItem(r *replacement) bool {
+	if r.kind != RefIssue || r.issue <= 0 {
+		return false
+	}
+	n := strconv.Itoa(r.issue)
+	t := string(l.orig[r.start:r.end])
+	return t == "#"+n || l.fullWidth && t == "＃"+n
+}
+
+// collapseIssueRanges collapses runs of consecutive issue references into single replacements. The
+// replacements must be sorted.
+func (l *Reflinker) collapseIssueRanges() {
+	reps := l.reps[:0]
+	for i := 0; i < len(l.reps); {
+		j := i + 1
+		if l.isIssueRangeItem(&l.reps[i]) {
+			for j < len(l.reps) {
+				prev, next := &l.reps[j-1], &l.reps[j]
+				sep := l.src[prev.end:next.start]
+				if !l.isIssueRangeItem(next) || next.issue != prev.issue+1 || len(sep) == 0 || len(bytes.Trim(sep, " ,")) > 0 {
+					break
+				}
+				j++
+			}
+		}
+
+		if j-i < 3 {
+			reps = append(reps, l.reps[i:j]...)
+			i = j
+			continue
+		}
+
+		first, last := l.reps[i], l.reps[j-1]
+		first.end = last.end
+		first.label = fmt.Sprintf("%s–%s", first.label, last.label)
+		slog.Debug("Collapsed consecutive issue references into range", "replacement", &first, "count", j-i)
+		reps = append(reps, first)
+		i = j
+	}
+	l.reps = reps
+}
+
+// removeDuplicateReplacements removes the replacements of references which already appeared earlier
+// in the text.
+func (l *Reflinker) removeDuplicateReplacements() {
+	sort.Sort(byStartOffset(l.reps))
+
+	type key struct {
+		kind RefKind
+		url  string
+	}
+	seen := map[key]struct{}{}
+	reps := l.reps[:0]
+	for _, r := range l.reps {
+		k := key{r.kind, r.url}
+		if r.kind == RefUser {
+			k.url = strings.ToLower(k.url) // User names are case-insensitive
+		}
+		if _, ok := seen[k]; ok {
+			slog.Debug("Skipped reference which already appeared", "replacement", &r)
+			l.skipped(l.orig[r.start:r.end], "already linked earlier")
+			continue
+		}
+		seen[k] = struct{}{}
+		reps = append(reps, r)
+	}
+	l.reps = reps
+}
+
+func (l *Reflinker) writeReplacements(w io.Writer) error {
+	return l.writeReplacementsWithProgress(w, nil)
+}
+
+// eachReplacement calls the function with each unlinked part of the original text and the replacement
+// following it in the order of offsets. The replacement is nil for the last part. Replacements
+// overlapping with the previous one are skipped. It stops at the first error returned from the
+// function.
+func (l *Reflinker) eachReplacement(f func(text []byte, r *replacement) error) error {
+	sort.Sort(byStartOffset(l.reps))
+
+	i := 0
+	for j := range l.reps {
+		r := &l.reps[j]
+		if r.start < i {
+			slog.Debug("Skipped replacement overlapping with previous one", "replacement", r, "previous_end", i)
+			continue // Keep the first one to avoid broken output
+		}
+		if err := f(l.orig[i:r.start], r); err != nil {
+			return err
+		}
+		i = r.end
+	}
+	return f(l.orig[i:], nil)
+}
+
+// writeReplacementsWithProgress writes the replacements and calls the progress callback with the
+// number of input bytes whose output has been written. The callback may be nil.
+func (l *Reflinker) writeReplacementsWithProgress(w io.Writer, progress func(int)) error {
+	offset := 0
+	if l.bom {
+		if _, err := w.Write(utf8BOM); err != nil {
+			return err
+		}
+		offset = len(utf8BOM)
+	}
+
+	return l.eachReplacement(func(text []byte, r *replacement) error {
+		if _, err := w.Write(text); err != nil {
+			return err
+		}
+		end := len(l.src)
+		if r != nil {
+			if _, err := io.WriteString(w, r.text()); err != nil {
+				return err
+			}
+			end = r.end
+		}
+		if progress != nil {
+			progress(offset + end)
+		}
+		return nil
+	})
+}
+
+func (l *Reflinker) appendReplacements(dst []byte) []byte {
+	if l.bom {
+		dst = append(dst, utf8BOM...)
+	}
+	l.eachReplacement(func(text []byte, r *replacement) error {
+		dst = append(dst, text...)
+		if r != nil {
+			dst = r.appendText(dst)
+		}
+		return nil
+	})
+	return dst
+}
+
+func (l *Reflinker) applyReplacements() string {
+	var b strings.Builder
+	l.writeReplacements(&b) // Writing to strings.Builder never fails
+	return b.String()
+}
+
+// linkText links all references in the text. References matched by regular expressions are linked
+// first and other references are detected in the rest of the text.
+func (l *Reflinker) linkText(start, end int) {
+	for start < end {
+		s, e := end, end
+		if len(l.regexps) > 0 {
+			s, e = l.linkRegexpRef(start, end)
+		}
+		l.linkGitHubRefs(start, s)
+		if l.version != nil {
+			l.linkVersionRefs(start, s)
+		}
+		l.linkExtRefs(start, s) // Detected last since built-in references win ties of overlaps
+		start = e
+	}
+}
+
+// isDelimiter returns true when the character may be an emphasis or strikethrough delimiter.
+func isDelimiter(b byte) bool {
+	return b == '_' || b == '*' || b == '~'
+}
+
+// changesDelimiter returns true when the link of the replacement changes the flanking of emphasis
+// delimiters next to it. For example, ** in #1**! can only close emphasis, but ** in [#1](...)**!
+// can also open emphasis since it follows the punctuation ')'.
+func (l *Reflinker) changesDelimiter(r *replacement) bool {
+	if r.start > 0 && isDelimiter(l.src[r.start-1]) && !isPunctOrSpace(l.src[r.start]) {
+		return true
+	}
+	// goldmark finds the character before a delimiter by skipping UTF-8 continuation bytes so the
+	// delimiter in #1\x9a** follows '1'
+	e := r.end
+	for e < len(l.src) && !utf8.RuneStart(l.src[e]) {
+		e++
+	}
+	return e < len(l.src) && isDelimiter(l.src[e]) && !isPunctOrSpace(l.src[r.end-1])
+}
+
+func isPunctOrSpace(b byte) bool {
+	return isSpace(b) || b < utf8.RuneSelf && isBoundary(b) && b != '_'
+}
+
+// stabilize removes the replacements which change the emphasis around them when linking the output
+// again links more references. For example, #0 in _#0__!#0_ is linked but the second #0 is not since
+// it is followed by '_'. However emphasis is parsed differently in the output _[#0](...)__!#0_ and
+// the second #0 is linked on linking the output again. Since re-parsing is costly, it is only done
+// when some replacement changes the flanking of delimiters next to it. Note that all the replacements
+// changing the flanking are removed even if only some of them change the parse.
+func (l *Reflinker) stabilize() {
+	if !slices.ContainsFunc(l.reps, func(r replacement) bool { return l.changesDelimiter(&r) }) {
+		return
+	}
+
+	c := *l
+	c.noVerify = true
+	c.bom = false
+	c.debugLog = nil
+	out := c.appendReplacements(nil)
+	if v := c.linkAll(out); len(v.reps) == 0 || bytes.Equal(v.appendReplacements(nil), out) {
+		return
+	}
+
+	reps := l.reps[:0]
+	for _, r := range l.reps {
+		if l.changesDelimiter(&r) {
+			slog.Debug("Skipped reference which changes emphasis around it", "replacement", &r)
+			l.skipped(l.orig[r.start:r.end], "linking it changes emphasis around it")
+			continue
+		}
+		reps = append(reps, r)
+	}
+	l.reps = reps
+}
+
+// labelText returns the text of the label of the link or image. Markups in the label like the
+// delimiters of code spans in [`41608e5f41`](...) are not included.
+func (l *Reflinker) labelText(n ast.Node) []byte {
+	var b []byte
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := n.(*ast.Text); ok && entering {
+			b = append(b, l.orig[t.Segment.Start:t.Segment.Stop]...)
+		}
+		return ast.WalkContinue, nil
+	})
+	return b
+}
+
+// recordTicks records the offsets of backticks in the text nodes under the node. They are not in
+// code spans since a backtick in text is not paired with any other backtick in the block.
+func (l *Reflinker) recordTicks(n ast.Node) {
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.CodeSpan:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			for i := n.Segment.Start; i < n.Segment.Stop; i++ {
+				if l.src[i] == '`' {
+					l.ticks = append(l.ticks, i)
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// Creating a parser allocates many objects so parsers are reused across calls
+var parserPool = sync.Pool{
+	New: func() any {
+		return goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser()
+	},
+}
+
+// indentedParagraphParser is a paragraph parser which can open a paragraph on a line indented with
+// 4 or more spaces
+type indentedParagraphParser struct {
+	parser.BlockParser
+}
+
+func (p indentedParagraphParser) CanAcceptIndentedLine() bool {
+	return true
+}
+
+// The parser for SetIndentedCodeBlocks(false). The parser for indented code blocks is replaced with
+// the one for paragraphs so that indented lines are parsed as paragraphs
+var noIndentedCodeParserPool = sync.Pool{
+	New: func() any {
+		code := reflect.TypeOf(parser.NewCodeBlockParser())
+		para := reflect.TypeOf(parser.NewParagraphParser())
+		var blocks []util.PrioritizedValue
+		for _, v := range parser.DefaultBlockParsers() {
+			switch reflect.TypeOf(v.Value) {
+			case code:
+			case para:
+				v.Value = indentedParagraphParser{parser.NewParagraphParser()}
+				blocks = append(blocks, v)
+			default:
+				blocks = append(blocks, v)
+			}
+		}
+		p := parser.NewParser(
+			parser.WithBlockParsers(blocks...),
+			parser.WithInlineParsers(parser.DefaultInlineParsers()...),
+			parser.WithParagraphTransformers(parser.DefaultParagraphTransformers()...),
+		)
+		return goldmark.New(goldmark.WithParser(p), goldmark.WithExtensions(extension.GFM)).Parser()
+	},
+}
+
+func parseMarkdown(src []byte) ast.Node {
+	p := parserPool.Get().(parser.Parser)
+	defer parserPool.Put(p)
+	return p.Parse(text.NewReader(src))
+}
+
+func (l *Reflinker) parse() ast.Node {
+	if !l.noIndentedCode {
+		return parseMarkdown(l.src)
+	}
+	p := noIndentedCodeParserPool.Get().(parser.Parser)
+	defer noIndentedCodeParserPool.Put(p)
+	return p.Parse(text.NewReader(l.src))
+}
+
+func (l *Reflinker) linkAll(src []byte) *Reflinker {
+	if l.disabled {
+		slog.Debug("Skipped linking references since it is disabled")
+		return l.start(src)
+	}
+	if l.maxInput > 0 && len(src) > l.maxInput {
+		slog.Debug("Skipped linking references since input is too large", "size", len(src), "max", l.maxInput)
+		return l.start(src)
+	}
+
+	l = l.start(src)
+	t := l.parse()
+	textStart := -1
+
+	ast.Walk(t, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		if n.Type() == ast.TypeBlock {
+			l.ticks = l.ticks[:0]
+		}
+
+		switch n := n.(type) {
+		case *ast.Link, *ast.Image:
+			l.recordTicks(n)
+			if t, ok := n.NextSibling().(*ast.Text); ok {
+				if l.isShortcutRefLinkEnd(t.Segment.Start) {
+					l.shortcuts = append(l.shortcuts, t.Segment.Start)
+				} else {
+					l.inlineLinks = append(l.inlineLinks, inlineLink{t.Segment.Start, l.labelText(n)})
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan:
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock, *ast.RawHTML:
+			// Lines of code blocks and raw HTML like <!-- #123 --> are not text nodes, but skip them
+			// explicitly in case goldmark changes it
+			return ast.WalkSkipChildren, nil
+		case *ast.AutoLink:
+			l.linkURL(n)
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			l.recordTicks(n)
+			// Combine all contiguous text nodes. For example, text nodes are split on '_'.
+			if textStart < 0 {
+				textStart = n.Segment.Start
+			}
+			// Link the combined text
+			if _, ok := n.NextSibling().(*ast.Text); !ok {
+				l.linkText(textStart, n.Segment.Stop)
+				textStart = -1
+			}
+			return ast.WalkSkipChildren, nil
+		default:
+			return ast.WalkContinue, nil
+		}
+	})
+
+	l.removeOverlappedReplacements()
+	if l.issueRanges {
+		l.collapseIssueRanges()
+	}
+	if l.firstOnly {
+		l.removeDuplicateReplacements()
+	}
+	if !l.noVerify {
+		l.stabilize()
+	}
+
+	slog.Debug("Total reference autolink replacements", "replacements", len(l.reps))
+	return l
+}
+
+// Link replaces all references in the given markdown text with actual links.
+func (l *Reflinker) Link(input string) string {
+	if l.disabled {
+		return input
+	}
+	l = l.linkAll([]byte(input))
+	if len(l.reps) == 0 {
+		return input
+	}
+	return l.applyReplacements()
+}
+
+// AppendLink appends the markdown text src where all references are replaced with actual links to
+// dst and returns the extended buffer like append. Callers can reuse the buffer across many inputs to
+// reduce allocations. src is not modified.
+func (l *Reflinker) AppendLink(dst, src []byte) []byte {
+	if l.disabled {
+		return append(dst, src...)
+	}
+	return l.linkAll(src).appendReplacements(dst)
+}
+
+// LinkBytes is the same as Link but accepts and returns byte slices.
+func (l *Reflinker) LinkBytes(src []byte) []byte {
+	return l.AppendLink(nil, src)
 }
 
-// NewReflinker creates Reflinker instance. repoURL is a repository URL of the service like
-// https://github.com/user/repo.
-func NewReflinker(repoURL string) *Reflinker {
-	u, err := url.Parse(repoURL)
-	if err != nil {
-		panic(err)
+// LinkAll replaces all references in each of the markdown texts with actual links like Link. The
+// results are returned in the same order as the inputs. The inputs are processed in parallel by
+// workers up to GOMAXPROCS.
+func (l *Reflinker) LinkAll(inputs []string) []string {
+	outputs := make([]string, len(inputs))
+	workers := min(runtime.GOMAXPROCS(0), len(inputs))
+	slog.Debug("Linking references in multiple texts", "inputs", len(inputs), "workers", workers)
+
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				outputs[i] = l.Link(inputs[i])
+			}
+		}()
 	}
-	u.Path = ""
-
-	l := &Reflinker{
-		repo: repoURL,
-		home: u.String(),
+	for i := range inputs {
+		idx <- i
 	}
-	l.AddExtRef("GH-", repoURL+"/issues/<num>", false)
-	return l
-}
+	close(idx)
+	wg.Wait()
 
-func (l *Reflinker) reset(src []byte) {
-	l.src = src
-	l.reps = nil
+	return outputs
 }
 
-func (l *Reflinker) isBoundaryAt(idx int) bool {
-	if idx < 0 || len(l.src) <= idx {
-		return true
+// Refs returns all references in the given markdown text which would be linked by Link. They are
+// sorted by their positions in the text.
+func (l *Reflinker) Refs(input string) []Ref {
+	l = l.linkAll([]byte(input))
+	sort.Sort(byStartOffset(l.reps))
+	refs := make([]Ref, 0, len(l.reps))
+	for _, r := range l.reps {
+		refs = append(refs, r.ref(l.orig))
 	}
-	return isBoundary(l.src[idx])
+	return refs
 }
 
-func (l *Reflinker) lastIndexIssueRef(offset, start, end int) int {
-	if start < offset && !l.isBoundaryAt(offset-1) {
-		return -1 // Issue ref must follow a boundary (e.g. 'foo#bar')
+// ReferencedIssues returns the sorted unique numbers of issues and pull requests in the repository
+// which are referenced in the given markdown text. References to other repositories are not
+// included. This is useful to generate a summary like "Referenced issues: #1, #5, #12".
+func (l *Reflinker) ReferencedIssues(input string) []int {
+	if l.issueRanges {
+		c := *l
+		c.issueRanges = false // Collapsed ranges lose the issue numbers in them
+		l = &c
 	}
-
-	for i := 1; offset+i < end; i++ {
-		b := l.src[offset+i]
-		if '0' <= b && b <= '9' {
-			continue
-		}
-		if i == 1 || !isBoundary(b) {
-			return -1
+	l = l.linkAll([]byte(input))
+	var nums []int
+	for _, r := range l.reps {
+		if r.issue > 0 {
+			nums = append(nums, r.issue)
 		}
-		return offset + i
 	}
-
-	return end // The text ends with issue number
+	slices.Sort(nums)
+	return slices.Compact(nums)
 }
 
-func (l *Reflinker) linkIssueRef(offset, start, end int) int {
-	e := l.lastIndexIssueRef(offset, start, end)
-	if e < 0 {
-		return offset + 1
-	}
+// LinkDiff returns a human-readable view of all changes which Link would make to the given markdown
+// text. Each change is shown in a unified-diff-like format with its position (1-based line and byte
+// column) and kind as follows. It returns an empty string when nothing would be changed. This is
+// useful to review the changes before applying them.
+//
+//	@@ 1:5 issue @@
+//	-#123
+//	+[#123](https://github.com/owner/repo/issues/123)
+func (l *Reflinker) LinkDiff(input string) string {
+	l = l.linkAll([]byte(input))
+	sort.Sort(byStartOffset(l.reps))
 
-	r := l.src[offset:e]
-	rep := replacement{
-		start: offset,
-		end:   e,
-		// Note: The link may be for PR, but GitHub can redirect this issue link to the PR
-		text: fmt.Sprintf("[%s](%s/issues/%s)", r, l.repo, r[1:]),
+	var b strings.Builder
+	for _, r := range l.reps {
+		line := bytes.Count(l.src[:r.start], []byte{'\n'}) + 1
+		col := r.start - (bytes.LastIndexByte(l.src[:r.start], '\n') + 1) + 1
+		fmt.Fprintf(&b, "@@ %d:%d %s @@\n-%s\n+%s\n", line, col, r.kind, l.orig[r.start:r.end], r.text())
 	}
-	slog.Debug("Found issue reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
-	l.reps = append(l.reps, rep)
-
-	return e
+	return b.String()
 }
 
-func (l *Reflinker) lastIndexUserRef(offset, start, end int) int {
-	if start < offset && !l.isBoundaryAt(offset-1) {
-		return -1 // e.g. foo@bar, _@foo (-@foo is ok)
-	}
+type jsonRef struct {
+	Ref
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
 
-	// Note: Username may only contain alphanumeric characters or single hyphens, and cannot begin
-	// or end with a hyphen: @foo-, @-foo
-	// Note: '/' just after user name like @foo/ is not allowed
+// LinkJSON returns all links generated by Link for the given markdown text as a JSON array. Each
+// element is an object with "kind", "text", "url", "start", and "end" fields. "start" and "end" are
+// the byte offsets of the reference in the input. The elements are sorted by "start". This is
+// useful for tools consuming the links structurally.
+func (l *Reflinker) LinkJSON(input string) ([]byte, error) {
+	l = l.linkAll([]byte(input))
+	sort.Sort(byStartOffset(l.reps))
 
-	if b := l.src[offset+1]; !isUserNameChar(b) || b == '-' {
-		return -1
+	offset := 0
+	if l.bom {
+		offset = len(utf8BOM) // Offsets in the input including the BOM
 	}
 
-	for i := 2; offset+i < end; i++ {
-		b := l.src[offset+i]
-		if isUserNameChar(b) {
-			continue
-		}
-		if !isBoundary(b) || b == '/' || l.src[offset+i-1] == '-' {
-			return -1
-		}
-		return offset + i
+	refs := make([]jsonRef, 0, len(l.reps))
+	for _, r := range l.reps {
+		refs = append(refs, jsonRef{r.ref(l.orig), offset + r.start, offset + r.end})
 	}
 
-	if l.src[end-1] == '-' {
-		return -1
+	b, err := json.Marshal(refs)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode links to JSON: %w", err)
 	}
+	return b, nil
+}
 
-	return end
+// Capabilities is a set of reference detection features enabled in the current configuration of
+// Reflinker. See Reflinker.Capabilities.
+type Capabilities struct {
+	Issues       bool // Issue references like #123
+	Users        bool // User references like @foo
+	LimitedUsers bool // Only some user references are linked due to SetUserAllowlist or SetMentionTriggers
+	Commits      bool // Commit hash references
+	ExtRefs      bool // External references like GH-123 and custom autolinks
+	RegexpRefs   bool // References added with AddRegexpRef
+	URLs         bool // Issue, pull request, commit, release, compare, and permalink URLs
+	GitLabRefs   bool // GitLab merge request references like !123 and milestone references like %123
+	Versions     bool // Version strings like v1.2.3 enabled with LinkVersionTags
+	ForeignHosts bool // URLs on other hosts enabled with LinkForeignHosts
 }
 
-func (l *Reflinker) linkUserRef(offset, start, end int) int {
-	e := l.lastIndexUserRef(offset, start, end)
-	if e < 0 {
-		return offset + 1
+// Capabilities returns the reference detection features enabled in the current configuration. This
+// is useful for applications to show which references will be linked. No feature is enabled when
+// linking is disabled by SetEnabled. User references are not enabled when the allowlist set by
+// SetUserAllowlist is empty.
+func (l *Reflinker) Capabilities() Capabilities {
+	if l.disabled {
+		return Capabilities{}
 	}
-
-	u := l.src[offset:e]
-	rep := replacement{
-		start: offset,
-		end:   e,
-		text:  fmt.Sprintf("[%s](%s/%s)", u, l.home, u[1:]),
+	users := l.users == nil || len(l.users) > 0
+	return Capabilities{
+		Issues:       true,
+		Users:        users,
+		LimitedUsers: users && (l.users != nil || len(l.mentionTrigs) > 0),
+		Commits:      true,
+		ExtRefs:      len(l.ext) > 0,
+		RegexpRefs:   len(l.regexps) > 0,
+		URLs:         true,
+		GitLabRefs:   l.flavor == FlavorGitLab,
+		Versions:     l.version != nil,
+		ForeignHosts: l.foreignHosts,
 	}
-	slog.Debug("Found user reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
-	l.reps = append(l.reps, rep)
-
-	return e
 }
 
-const hashLen int = 40
-
-func (l *Reflinker) linkCommitSHA(offset, start, end int) int {
-	for i := 1; i < hashLen; i++ { // Since l.src[offset] was already checked, i starts from 1
-		if offset+i >= end {
-			return offset + i
-		}
-		b := l.src[offset+i]
-		if '0' <= b && b <= '9' || 'a' <= b && b <= 'f' {
-			continue
-		}
-		return offset + i
+// SupportedRefKinds returns the kinds of references which can be linked with the current
+// configuration. The kinds are in the order of their declarations.
+func (l *Reflinker) SupportedRefKinds() []RefKind {
+	c := l.Capabilities()
+	var kinds []RefKind
+	if c.Issues {
+		kinds = append(kinds, RefIssue)
 	}
-
-	hashEnd := offset + hashLen
-	if (start == offset || l.isBoundaryAt(offset-1)) && (hashEnd == end || l.isBoundaryAt(hashEnd)) {
-		h := l.src[offset:hashEnd]
-		rep := replacement{
-			start: offset,
-			end:   offset + hashLen,
-			text:  fmt.Sprintf("[`%s`](%s/commit/%s)", h[:10], l.repo, h),
-		}
-		slog.Debug("Found commit hash reference autolink", "replacement", &rep, "offset", offset, "start", start, "end", end)
-		l.reps = append(l.reps, rep)
+	if c.Users {
+		kinds = append(kinds, RefUser)
 	}
-
-	return offset + hashLen
+	if c.Commits {
+		kinds = append(kinds, RefCommit)
+	}
+	if c.ExtRefs {
+		kinds = append(kinds, RefExt)
+	}
+	if c.URLs {
+		kinds = append(kinds, RefIssueURL, RefCommitURL)
+	}
+	if c.GitLabRefs {
+		kinds = append(kinds, RefMergeRequest, RefMilestone)
+	}
+	if c.Versions {
+		kinds = append(kinds, RefVersion)
+	}
+	if c.URLs {
+		kinds = append(kinds, RefReleaseURL, RefCompareURL, RefBlobURL, RefAdvisoryURL)
+	}
+	if c.RegexpRefs {
+		kinds = append(kinds, RefRegexp)
+	}
+	return kinds
 }
 
-func (l *Reflinker) linkGitHubRefs(start, stop int) {
-	o := start
-
-	for o < stop-1 { // `-1` means the last character is not checked
-		s := l.src[o:stop]
-		i := bytes.IndexAny(s, "#@1234567890abcdef")
-		if i < 0 || len(s)-1 <= i {
-			return
-		}
+// LinkStats is the numbers of links generated by each kind of reference in a Link call.
+type LinkStats struct {
+	Issues   int // Issue references like #123 (and !123, %123 on GitLab)
+	Users    int // User references like @foo
+	Commits  int // Commit hash references
+	URLs     int // Issue, pull request, commit, release, compare, permalink, and security advisory URLs
+	ExtRefs  int // External references like GH-123, custom autolinks, and regular expression references
+	Versions int // Version strings like v1.2.3
+}
 
-		switch s[i] {
-		case '#':
-			o = l.linkIssueRef(o+i, start, stop)
-		case '@':
-			o = l.linkUserRef(o+i, start, stop)
-		default:
-			// hex character [0-9a-f]
-			o = l.linkCommitSHA(o+i, start, stop)
+func (l *Reflinker) stats() LinkStats {
+	var s LinkStats
+	for _, r := range l.reps {
+		switch r.kind {
+		case RefIssue, RefMergeRequest, RefMilestone:
+			s.Issues++
+		case RefUser:
+			s.Users++
+		case RefCommit:
+			s.Commits++
+		case RefIssueURL, RefCommitURL, RefReleaseURL, RefCompareURL, RefBlobURL, RefAdvisoryURL:
+			s.URLs++
+		case RefExt, RefRegexp:
+			s.ExtRefs++
+		case RefVersion:
+			s.Versions++
 		}
 	}
+	return s
 }
 
-// AddExtRef adds external refeerence. Parameters are corresponding to the API:
-// https://docs.github.com/en/rest/repos/autolinks?apiVersion=2022-11-28
-func (l *Reflinker) AddExtRef(prefix, url string, alphanumeric bool) {
-	prefix = regexp.QuoteMeta(prefix)
-
-	var r *regexp.Regexp
-	if alphanumeric {
-		r = regexp.MustCompile(`\b` + prefix + `[a-zA-Z0-9_]+`)
-	} else {
-		r = regexp.MustCompile(`\b` + prefix + `\d+\b`)
+// LinkWithReport is the same as Link but also returns how many references were linked by each kind.
+func (l *Reflinker) LinkWithReport(input string) (string, LinkStats) {
+	if l.disabled {
+		return input, LinkStats{}
+	}
+	l = l.linkAll([]byte(input))
+	s := l.stats()
+	slog.Debug("Linked references", "stats", s)
+	if len(l.reps) == 0 {
+		return input, s
 	}
+	return l.applyReplacements(), s
+}
 
-	l.ext = append(l.ext, extRef{prefix, r, url})
+// LinkTo replaces all references in the given markdown text with actual links and writes the result
+// to the writer. Unlike Link, the whole output is not buffered in memory. This is useful for large
+// inputs like an aggregated changelog file. Note that the input is still entirely parsed in memory.
+func (l *Reflinker) LinkTo(w io.Writer, input []byte) error {
+	return l.linkAll(input).writeReplacements(w)
 }
 
-func (l *Reflinker) linkExtRef(start, end int) int {
-	src := l.src[start:end]
-	for _, ext := range l.ext {
-		if r := ext.pat.FindIndex(src); r != nil {
-			s, e := r[0], r[1]
-			ref := src[s:e]
-			num := ref[len(ext.prefix):]
-			url := strings.ReplaceAll(ext.url, "<num>", string(num))
-			rep := replacement{
-				start: start + s,
-				end:   start + e,
-				text:  fmt.Sprintf("[%s](%s)", ref, url),
-			}
-			slog.Debug("Found external resource (custom) autolink", "replacement", &rep, "start", start, "end", end)
-			l.reps = append(l.reps, rep)
-			return start + e
-		}
+// LinkStream reads the markdown text from the reader and writes the text where all references are
+// replaced with actual links to the writer. The input is still entirely parsed in memory, but the
+// output is written incrementally. The progress callback is called with the number of input bytes
+// processed so far each time a link is written, and finally with the size of the whole input. This
+// is useful to show a progress bar for large inputs. The callback may be nil.
+func (l *Reflinker) LinkStream(r io.Reader, w io.Writer, progress func(bytesDone int)) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("could not read markdown text to link references: %w", err)
 	}
-	return end // Not found
+	if err := l.linkAll(b).writeReplacementsWithProgress(w, progress); err != nil {
+		return fmt.Errorf("could not write linked markdown text: %w", err)
+	}
+	return nil
 }
 
-func (l *Reflinker) linkExtRefs(start, stop int) {
-	o := start
-	for o < stop-1 {
-		o = l.linkExtRef(o, stop)
+// LinkFileDryRun reads the markdown file at the path and returns its content where all references
+// are replaced with actual links. The file is not modified.
+func (l *Reflinker) LinkFileDryRun(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read markdown file to link references: %w", err)
 	}
+	return l.Link(string(b)), nil
 }
 
-// Commit URL with fragment should not be converted to a reference link.
-// e.g. https://github.com/rhysd/changelog-from-release/commit/096c8152092281371e88265dd43b1b7d23a88453#diff-ced928ba39db1f56ef7862baebfe0314ed06f433a71defdc60a2b12e67011453L226
-var reGitHubCommitPath = regexp.MustCompile(`^/([^/]+/[^/]+)/commit/([[:xdigit:]]{7,})$`)
-
-func (l *Reflinker) linkCommitURL(m [][]byte, url []byte, start, end int) {
-	slug, hash := m[1], m[2]
-	if len(hash) > 10 {
-		hash = hash[:10]
+// LinkFile replaces all references in the markdown file at the path with actual links in place. The
+// file is atomically updated by renaming a temporary file in the same directory. The file mode is
+// preserved.
+func (l *Reflinker) LinkFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("could not stat markdown file to link references: %w", err)
 	}
 
-	var replaced string
-	if bytes.HasPrefix(url, []byte(l.repo)) {
-		replaced = fmt.Sprintf("[`%s`](%s)", hash, url)
-	} else {
-		replaced = fmt.Sprintf("[%s@`%s`](%s)", slug, hash, url)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read markdown file to link references: %w", err)
 	}
 
-	rep := replacement{
-		start: start,
-		end:   end,
-		text:  replaced,
+	l = l.linkAll(b)
+	if len(l.reps) == 0 {
+		slog.Debug("File was not updated since no reference was found", "path", path)
+		return nil
 	}
-	slog.Debug("Converted commit URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
-	l.reps = append(l.reps, rep)
-}
-
-// Consider URL with fragment which links to issue comments.
-// e.g.
-// - https://github.com/rhysd/changelog-from-release/issues/11#issue-1327166917
-// - https://github.com/rhysd/changelog-from-release/issues/11#issuecomment-1346614286
-// - https://github.com/rhysd/changelog-from-release/pull/15#pullrequestreview-1212591132
-// - https://github.com/rhysd/changelog-from-release/pull/15#discussion_r1045110870
-var reGitHubIssuePath = regexp.MustCompile(`^/([^/]+/[^/]+)/(?:pull|issues)/(\d+)(#.+)?$`)
 
-func (l *Reflinker) linkIssueURL(m [][]byte, url []byte, start, end int) {
-	slug, num := m[1], m[2]
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file to update %q: %w", path, err)
+	}
+	tmp := f.Name()
+	slog.Debug("Writing linked text to temporary file", "path", path, "tmp", tmp, "replacements", len(l.reps))
 
-	// When hash like #issue-12345 follows, it links to a comment in the issue thread
-	var note string
-	if len(m[3]) > 0 {
-		if bytes.HasPrefix(m[3], []byte("#pullrequestreview-")) {
-			note = " (review)"
-		} else {
-			note = " (comment)"
-		}
+	if err := l.writeReplacements(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("could not write linked text to temporary file %q: %w", tmp, err)
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("could not change mode of temporary file %q: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not close temporary file %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not replace %q with linked text: %w", path, err)
 	}
 
-	var replaced string
-	if bytes.HasPrefix(url, []byte(l.repo)) {
-		replaced = fmt.Sprintf("[#%s%s](%s)", num, note, url)
-	} else {
-		replaced = fmt.Sprintf("[%s#%s%s](%s)", slug, num, note, url)
+	return nil
+}
+
+// lastIndexLinkDest returns the offset just after the link destination or the reference label
+// which starts at the offset. e.g. '(https://example.com "title")' or '[label]'.
+func (l *Reflinker) lastIndexLinkDest(offset int) int {
+	if offset >= len(l.src) {
+		return offset
 	}
 
-	rep := replacement{
-		start: start,
-		end:   end,
-		text:  replaced,
+	switch l.src[offset] {
+	case '(':
+		depth := 0
+		for i := offset; i < len(l.src); i++ {
+			switch l.src[i] {
+			case '\\':
+				i++
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return -1
+	case '[':
+		i := bytes.IndexByte(l.src[offset:], ']')
+		if i < 0 {
+			return -1
+		}
+		return offset + i + 1
+	default:
+		return offset // Shortcut reference link like [label]
 	}
-	slog.Debug("Converted issue/PR URL to reference autolink", "replacement", &rep, "url", url, "start", start, "end", end)
-	l.reps = append(l.reps, rep)
 }
 
-func (l *Reflinker) linkURL(n *ast.AutoLink) {
-	start := 0
-	if p := n.PreviousSibling(); p != nil {
-		t := p.(*ast.Text)
-		if t == nil {
-			return
+func (l *Reflinker) unlink(n *ast.Link) {
+	// Find the range of the link label from the text nodes in it. The label may contain other
+	// inline nodes like **bold** or `code`.
+	first, last := -1, -1
+	image := false
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if _, ok := c.(*ast.Image); ok {
+			image = true
+			return ast.WalkStop, nil
+		}
+		if t, ok := c.(*ast.Text); ok && entering {
+			if first < 0 {
+				first = t.Segment.Start
+			}
+			last = t.Segment.Stop
 		}
-		start = t.Segment.Stop
+		return ast.WalkContinue, nil
+	})
+	if image {
+		return // The text nodes don't tell the range of the label like [![img](a.png)](https://example.com)
 	}
-
-	home := []byte(l.home)
-	url := n.URL(l.src)
-	if !bytes.HasPrefix(url, home) {
-		return
+	if first < 0 {
+		return // Link with empty label like [](https://example.com)
 	}
 
-	// Search the offset of the start of the URL. When the text is a child of some other node, URL
-	// may not appear just after the previous node. The example is **https://...** where URL appears
-	// after the first **.
-	offset := bytes.Index(l.src[start:], url)
-	if offset < 0 {
+	start := bytes.LastIndexByte(l.src[:first], '[')
+	if start < 0 {
 		return
 	}
-	start += offset
-
-	end := start + len(url)
-	if start >= len(l.src) || end > len(l.src) {
+	i := bytes.IndexByte(l.src[last:], ']')
+	if i < 0 {
 		return
 	}
-
-	// Note: `end` is the index of the character just after the URL
-	if start > 0 && l.src[start-1] == '<' && end < len(l.src) && l.src[end] == '>' {
+	labelEnd := last + i
+	end := l.lastIndexLinkDest(labelEnd + 1)
+	if end < 0 {
 		return
 	}
 
-	path := url[len(home):]
-
-	if m := reGitHubCommitPath.FindSubmatch(path); m != nil {
-		l.linkCommitURL(m, url, start, end)
-	} else if m := reGitHubIssuePath.FindSubmatch(path); m != nil {
-		l.linkIssueURL(m, url, start, end)
-	}
-}
-
-func (l *Reflinker) applyReplacements() string {
-	sort.Sort(byStartOffset(l.reps))
-
-	var b strings.Builder
-	i := 0
-	for _, r := range l.reps {
-		b.Write(l.src[i:r.start])
-		b.WriteString(r.text)
-		i = r.end
+	rep := replacement{
+		start: start,
+		end:   end,
+		label: string(l.orig[start+1 : labelEnd]),
 	}
-	b.Write(l.src[i:])
-	return b.String()
+	slog.Debug("Flattened link to its label", "replacement", &rep)
+	l.reps = append(l.reps, rep)
 }
 
-// Link replaces all references in the given markdown text with actual links.
-func (l *Reflinker) Link(input string) string {
+// Unlink is the inverse of Link. It replaces all links in the given markdown text with their labels.
+// References like #123 and links containing images are left as they are. This is useful to generate
+// a plain text changelog.
+func (l *Reflinker) Unlink(input string) string {
 	src := []byte(input)
-	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
-	t := md.Parser().Parse(text.NewReader(src))
-	l.reset(src)
-	textStart := -1
+	l = l.start(src)
+	t := l.parse()
 
 	ast.Walk(t, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
 			return ast.WalkContinue, nil
 		}
-
-		switch n := n.(type) {
-		case *ast.CodeSpan, *ast.Link:
+		if n, ok := n.(*ast.Link); ok {
+			l.unlink(n)
 			return ast.WalkSkipChildren, nil
-		case *ast.AutoLink:
-			l.linkURL(n)
-			return ast.WalkSkipChildren, nil
-		case *ast.Text:
-			// Combine all contiguous text nodes. For example, text nodes are split on '_'.
-			if textStart < 0 {
-				textStart = n.Segment.Start
-			}
-			// Link the combined text
-			if _, ok := n.NextSibling().(*ast.Text); !ok {
-				l.linkGitHubRefs(textStart, n.Segment.Stop)
-				l.linkExtRefs(textStart, n.Segment.Stop)
-				textStart = -1
-			}
-			return ast.WalkSkipChildren, nil
-		default:
-			return ast.WalkContinue, nil
 		}
+		return ast.WalkContinue, nil
 	})
 
-	slog.Debug("Total reference autolink replacements", "replacements", len(l.reps))
+	slog.Debug("Total flattened links", "replacements", len(l.reps))
 	if len(l.reps) == 0 {
 		return input
 	}
@@ -1,6 +1,24 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/yuin/goldmark/ast"
+)
 
 func TestLinkRefs(t *testing.T) {
 	tests := []struct {
@@ -19,6 +37,31 @@ func TestLinkRefs(t *testing.T) {
 			input: "@foo",
 			want:  "[@foo](https://github.com/foo)",
 		},
+		{
+			what:  "organization",
+			input: "@github",
+			want:  "[@github](https://github.com/github)",
+		},
+		{
+			what:  "deleted user placeholder",
+			input: "@ghost",
+			want:  "[@ghost](https://github.com/ghost)",
+		},
+		{
+			what:  "user includes double hyphens",
+			input: "@foo--bar",
+			want:  "@foo--bar",
+		},
+		{
+			what:  "user includes triple hyphens",
+			input: "@foo---bar",
+			want:  "@foo---bar",
+		},
+		{
+			what:  "user ends with double hyphens",
+			input: "@foo-- @bar",
+			want:  "@foo-- [@bar](https://github.com/bar)",
+		},
 		{
 			what:  "user includes hyphen",
 			input: "@a-B-2",
@@ -89,6 +132,26 @@ func TestLinkRefs(t *testing.T) {
 			input: "*#123*",
 			want:  "*[#123](https://github.com/u/r/issues/123)*", // Linked because it's an italic text
 		},
+		{
+			what:  "issue as bold text with asterisks",
+			input: "**#123**",
+			want:  "**[#123](https://github.com/u/r/issues/123)**",
+		},
+		{
+			what:  "issue as bold italic text with asterisks",
+			input: "***#123***",
+			want:  "***[#123](https://github.com/u/r/issues/123)***",
+		},
+		{
+			what:  "issue in italic text mixing asterisk and underscore",
+			input: "*_#123_* _*#123*_",
+			want:  "*_[#123](https://github.com/u/r/issues/123)_* _*[#123](https://github.com/u/r/issues/123)*_",
+		},
+		{
+			what:  "issue next to asterisk italic",
+			input: "*foo*#123 #123*foo*",
+			want:  "*foo*[#123](https://github.com/u/r/issues/123) [#123](https://github.com/u/r/issues/123)*foo*",
+		},
 		{
 			what:  "issue as bold text",
 			input: "__#123__",
@@ -139,6 +202,21 @@ func TestLinkRefs(t *testing.T) {
 			input: "い#1🐶#2ぬ",
 			want:  "い[#1](https://github.com/u/r/issues/1)🐶[#2](https://github.com/u/r/issues/2)ぬ",
 		},
+		{
+			what:  "space after at",
+			input: "@ user and @\tuser",
+			want:  "@ user and @\tuser",
+		},
+		{
+			what:  "user follows at",
+			input: "@@user and @@@user",
+			want:  "@[@user](https://github.com/user) and @@[@user](https://github.com/user)",
+		},
+		{
+			what:  "only at characters",
+			input: "@@",
+			want:  "@@",
+		},
 		{
 			what:  "user follows alphabet",
 			input: "a@foo",
@@ -164,11 +242,41 @@ func TestLinkRefs(t *testing.T) {
 			input: "_foo @foo_",
 			want:  "_foo [@foo](https://github.com/foo)_", // Linked because of italic text
 		},
+		{
+			what:  "user as bold italic text",
+			input: "***@foo***",
+			want:  "***[@foo](https://github.com/foo)***",
+		},
+		{
+			what:  "user as bold italic text with underscores",
+			input: "___@foo___",
+			want:  "___[@foo](https://github.com/foo)___", // Linked because of emphasis
+		},
+		{
+			what:  "issue as bold italic text with underscores",
+			input: "___#123___",
+			want:  "___[#123](https://github.com/u/r/issues/123)___", // Linked because of emphasis
+		},
+		{
+			what:  "references in nested emphasis mixing asterisks and underscores",
+			input: "**_@foo_** _**#1**_",
+			want:  "**_[@foo](https://github.com/foo)_** _**[#1](https://github.com/u/r/issues/1)**_",
+		},
 		{
 			what:  "user follows hyphen",
 			input: "-@foo",
 			want:  "-[@foo](https://github.com/foo)",
 		},
+		{
+			what:  "user at end of hyphenated compound",
+			input: "co-author-@foo and user-@bar",
+			want:  "co-author-[@foo](https://github.com/foo) and user-[@bar](https://github.com/bar)",
+		},
+		{
+			what:  "user follows user ending with hyphen",
+			input: "@foo-@bar",
+			want:  "@foo-[@bar](https://github.com/bar)", // @foo- is not a valid user name
+		},
 		{
 			what:  "user ends with hyphen",
 			input: "@foo-",
@@ -204,6 +312,86 @@ func TestLinkRefs(t *testing.T) {
 			input: "!@a?",
 			want:  "![@a](https://github.com/a)?",
 		},
+		{
+			what:  "user in parenthetical attribution",
+			input: "Fix crash (thanks @foo)",
+			want:  "Fix crash (thanks [@foo](https://github.com/foo))",
+		},
+		{
+			what:  "users in parentheses",
+			input: "(@foo, @bar) (@piyo)",
+			want:  "([@foo](https://github.com/foo), [@bar](https://github.com/bar)) ([@piyo](https://github.com/piyo))",
+		},
+		{
+			what:  "user in parentheses at end of input",
+			input: "foo (@bar)",
+			want:  "foo ([@bar](https://github.com/bar))",
+		},
+		{
+			what:  "users in adjacent parentheses",
+			input: "(@foo)(@bar)",
+			want:  "([@foo](https://github.com/foo))([@bar](https://github.com/bar))",
+		},
+		{
+			what:  "emoji shortcodes around references",
+			input: ":tada:#123 @foo:+1: :bug:41608e5f4109208a6ab995c58266554e6071c5b2:bug:",
+			want:  ":tada:[#123](https://github.com/u/r/issues/123) [@foo](https://github.com/foo):+1: :bug:[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2):bug:",
+		},
+		{
+			what:  "emoji shortcode only",
+			input: ":tada: :face_with_hand_over_mouth: :+1:",
+			want:  ":tada: :face_with_hand_over_mouth: :+1:",
+		},
+		{
+			what:  "user in angle brackets",
+			input: "<@foo> and <#1>",
+			want:  "<[@foo](https://github.com/foo)> and <[#1](https://github.com/u/r/issues/1)>",
+		},
+		{
+			what:  "email autolink in angle brackets",
+			input: "<foo@example.com>",
+			want:  "<foo@example.com>",
+		},
+		{
+			what:  "user followed by colon",
+			input: "@foo: fixed the bug",
+			want:  "[@foo](https://github.com/foo): fixed the bug",
+		},
+		{
+			what:  "users followed by punctuations",
+			input: "@foo; @bar. @piyo, @a! @b?",
+			want:  "[@foo](https://github.com/foo); [@bar](https://github.com/bar). [@piyo](https://github.com/piyo), [@a](https://github.com/a)! [@b](https://github.com/b)?",
+		},
+		{
+			what:  "users followed by punctuation at end of input",
+			input: "thanks @foo.",
+			want:  "thanks [@foo](https://github.com/foo).",
+		},
+		{
+			what:  "user followed by non-ASCII Latin letter",
+			input: "@café @Zoë",
+			want:  "@café @Zoë",
+		},
+		{
+			what:  "user followed by combining mark",
+			input: "@cafe\u0301 @foo",
+			want:  "@cafe\u0301 [@foo](https://github.com/foo)",
+		},
+		{
+			what:  "users after unordered list markers",
+			input: "- @foo fixed it\n* @bar\n+ @piyo",
+			want:  "- [@foo](https://github.com/foo) fixed it\n* [@bar](https://github.com/bar)\n+ [@piyo](https://github.com/piyo)",
+		},
+		{
+			what:  "users after ordered list markers",
+			input: "1. @foo\n2. @bar\n\n10) @piyo",
+			want:  "1. [@foo](https://github.com/foo)\n2. [@bar](https://github.com/bar)\n\n10) [@piyo](https://github.com/piyo)",
+		},
+		{
+			what:  "user after nested list marker",
+			input: "- foo\n  - @bar",
+			want:  "- foo\n  - [@bar](https://github.com/bar)",
+		},
 		{
 			what:  "user among multibyte characters",
 			input: "い@X🐶@Yぬ",
@@ -254,6 +442,46 @@ func TestLinkRefs(t *testing.T) {
 			input: "> @foo\n> #1",
 			want:  "> [@foo](https://github.com/foo)\n> [#1](https://github.com/u/r/issues/1)",
 		},
+		{
+			what:  "user reference before link",
+			input: "@foo[link](https://example.com)",
+			want:  "[@foo](https://github.com/foo)[link](https://example.com)",
+		},
+		{
+			what:  "issue reference before link",
+			input: "#123[link](https://example.com)",
+			want:  "[#123](https://github.com/u/r/issues/123)[link](https://example.com)",
+		},
+		{
+			what:  "slug issue reference before link",
+			input: "foo/bar#1[link](https://example.com)",
+			want:  "[foo/bar#1](https://github.com/foo/bar/issues/1)[link](https://example.com)",
+		},
+		{
+			what:  "user reference before link containing user reference",
+			input: "@foo[@bar](https://example.com)",
+			want:  "[@foo](https://github.com/foo)[@bar](https://example.com)",
+		},
+		{
+			what:  "nested quote",
+			input: "> > #123 @foo",
+			want:  "> > [#123](https://github.com/u/r/issues/123) [@foo](https://github.com/foo)",
+		},
+		{
+			what:  "nested quote without spaces",
+			input: ">>#123",
+			want:  ">>[#123](https://github.com/u/r/issues/123)",
+		},
+		{
+			what:  "multiple levels of quotes",
+			input: "> #1\n> > #2\n> > > https://github.com/u/r/pull/3",
+			want:  "> [#1](https://github.com/u/r/issues/1)\n> > [#2](https://github.com/u/r/issues/2)\n> > > [#3](https://github.com/u/r/pull/3)",
+		},
+		{
+			what:  "refs in quote in list",
+			input: "- item\n  > > #1 and #2",
+			want:  "- item\n  > > [#1](https://github.com/u/r/issues/1) and [#2](https://github.com/u/r/issues/2)",
+		},
 		{
 			what:  "issue in link",
 			input: "[oops #1](https://example.com/foo/bar?a=b#frag)",
@@ -264,6 +492,87 @@ func TestLinkRefs(t *testing.T) {
 			input: "[@foo woo](https://example.com/foo/bar?a=b#frag)",
 			want:  "[@foo woo](https://example.com/foo/bar?a=b#frag)",
 		},
+		{
+			what:  "user after inline link",
+			input: "[see here](http://x) and thanks @user",
+			want:  "[see here](http://x) and thanks [@user](https://github.com/user)",
+		},
+		{
+			// ')' closing a link is not a boundary so that linking the output again is no-op
+			what:  "references just after inline links",
+			input: "[@foo](http://x)@bar [#1](http://y)#2",
+			want:  "[@foo](http://x)@bar [#1](http://y)#2",
+		},
+		{
+			what:  "references just after inline links to other texts",
+			input: "[see](https://example.com)#123 [foo](http://x)@bar",
+			want:  "[see](https://example.com)[#123](https://github.com/u/r/issues/123) [foo](http://x)[@bar](https://github.com/bar)",
+		},
+		{
+			what:  "commit reference after unpaired backtick",
+			input: "` foo/bar@41608e5 and 41608e5f4109208a6ab995c58266554e6071c5b2\n\n41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "` [foo/bar@41608e5](https://github.com/foo/bar/commit/41608e5) and [41608e5f41](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)\n\n[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "commit reference after closed code span and even number of backticks",
+			input: "`foo` and `` 41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "`foo` and `` [`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "user after inline link with title",
+			input: "[@foo](http://x \"@bar\") @baz",
+			want:  "[@foo](http://x \"@bar\") [@baz](https://github.com/baz)",
+		},
+		{
+			what:  "issue after link label",
+			input: "[a]#1",
+			want:  "[a][#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "issue after shortcut reference link",
+			input: "[a]#1\n\n[a]: https://example.com",
+			want:  "[a][][#1](https://github.com/u/r/issues/1)\n\n[a]: https://example.com",
+		},
+		{
+			what:  "issue after full reference link",
+			input: "[a][b]#1\n\n[b]: https://example.com",
+			want:  "[a][b][#1](https://github.com/u/r/issues/1)\n\n[b]: https://example.com",
+		},
+		{
+			what:  "issue after collapsed reference link",
+			input: "[a][]#1\n\n[a]: https://example.com",
+			want:  "[a][][#1](https://github.com/u/r/issues/1)\n\n[a]: https://example.com",
+		},
+		{
+			what:  "issue after shortcut reference link with emphasis",
+			input: "[*a*]#1\n\n[*a*]: https://example.com",
+			want:  "[*a*][][#1](https://github.com/u/r/issues/1)\n\n[*a*]: https://example.com",
+		},
+		{
+			what:  "user after shortcut reference image",
+			input: "![a]@foo\n\n[a]: https://example.com/a.png",
+			want:  "![a][][@foo](https://github.com/foo)\n\n[a]: https://example.com/a.png",
+		},
+		{
+			what:  "issue apart from shortcut reference link",
+			input: "[a] #1\n\n[a]: https://example.com",
+			want:  "[a] [#1](https://github.com/u/r/issues/1)\n\n[a]: https://example.com",
+		},
+		{
+			what:  "references in image alt text",
+			input: "![alt #123 @foo](https://example.com/a.png)",
+			want:  "![alt #123 @foo](https://example.com/a.png)",
+		},
+		{
+			what:  "reference in image title",
+			input: "![img](https://example.com/a.png \"title #1\") #2",
+			want:  "![img](https://example.com/a.png \"title #1\") [#2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:  "image in link",
+			input: "[![badge #1](https://example.com/b.svg)](https://example.com)",
+			want:  "[![badge #1](https://example.com/b.svg)](https://example.com)",
+		},
 		{
 			what:  "italic",
 			input: "*@foo* *#1*",
@@ -289,11 +598,272 @@ func TestLinkRefs(t *testing.T) {
 			input: "```\n#123\n@foo\n```",
 			want:  "```\n#123\n@foo\n```",
 		},
+		{
+			what:  "code fence with language",
+			input: "```go\n// #123 @foo 41608e5f4109208a6ab995c58266554e6071c5b2\n```",
+			want:  "```go\n// #123 @foo 41608e5f4109208a6ab995c58266554e6071c5b2\n```",
+		},
+		{
+			what:  "code fence with tildes",
+			input: "~~~\n#123 @foo GH-1\nhttps://github.com/u/r/issues/1\n~~~",
+			want:  "~~~\n#123 @foo GH-1\nhttps://github.com/u/r/issues/1\n~~~",
+		},
+		{
+			what:  "code fence in list",
+			input: "- #1\n  ```\n  #2\n  ```",
+			want:  "- [#1](https://github.com/u/r/issues/1)\n  ```\n  #2\n  ```",
+		},
+		{
+			what:  "unclosed code fence",
+			input: "#1\n```\n#2 @foo",
+			want:  "[#1](https://github.com/u/r/issues/1)\n```\n#2 @foo",
+		},
+		{
+			what:  "indented code block",
+			input: "#1\n\n    #2 @foo 41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[#1](https://github.com/u/r/issues/1)\n\n    #2 @foo 41608e5f4109208a6ab995c58266554e6071c5b2",
+		},
 		{
 			what:  "<pre> html element",
 			input: "<pre>hi #123 @foo</pre>",
 			want:  "<pre>hi #123 @foo</pre>",
 		},
+		{
+			what:  "html comment",
+			input: "<!-- #123 @foo -->",
+			want:  "<!-- #123 @foo -->",
+		},
+		{
+			what:  "inline html comment",
+			input: "#1 <!-- #2 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 --> #3",
+			want:  "[#1](https://github.com/u/r/issues/1) <!-- #2 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 --> [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "multi-line html comment",
+			input: "<!--\nRelease notes template\n#123 @foo\n-->\n#1",
+			want:  "<!--\nRelease notes template\n#123 @foo\n-->\n[#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "multi-line inline html comment",
+			input: "fix #1 <!--\n#2\n--> #3",
+			want:  "fix [#1](https://github.com/u/r/issues/1) <!--\n#2\n--> [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "details block",
+			input: "<details><summary>Fix #1</summary>\n\nBody #123 https://github.com/u/r/issues/2\n\n</details>",
+			want:  "<details><summary>Fix #1</summary>\n\nBody [#123](https://github.com/u/r/issues/123) [#2](https://github.com/u/r/issues/2)\n\n</details>",
+		},
+		{
+			what:  "details block with list",
+			input: "<details>\n<summary>Changes in #1</summary>\n\n- #123 by @foo\n- https://github.com/u/r/pull/2\n\n</details>",
+			want:  "<details>\n<summary>Changes in #1</summary>\n\n- [#123](https://github.com/u/r/issues/123) by [@foo](https://github.com/foo)\n- [#2](https://github.com/u/r/pull/2)\n\n</details>",
+		},
+		{
+			what:  "details block without blank line is html block",
+			input: "<details>\n<summary>#1</summary>\n#123\n</details>",
+			want:  "<details>\n<summary>#1</summary>\n#123\n</details>",
+		},
+		{
+			what:  "escaped issue reference with html entity",
+			input: "&#35;123 &#x23;123 &num;123",
+			want:  "&#35;123 &#x23;123 &num;123",
+		},
+		{
+			what:  "escaped user reference with html entity",
+			input: "&#64;foo &commat;foo",
+			want:  "&#64;foo &commat;foo",
+		},
+		{
+			what:  "html entity before issue reference",
+			input: "&amp;#123 &lt;#124&gt;",
+			want:  "&amp;[#123](https://github.com/u/r/issues/123) &lt;[#124](https://github.com/u/r/issues/124)&gt;",
+		},
+		{
+			what:  "same URL in angle brackets in previous paragraph",
+			input: "<https://github.com/u/r/issues/1>\n\nhttps://github.com/u/r/issues/1",
+			want:  "<https://github.com/u/r/issues/1>\n\n[#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "same URL in previous paragraph and in bold text",
+			input: "https://github.com/u/r/issues/1\n\n**https://github.com/u/r/issues/1**",
+			want:  "[#1](https://github.com/u/r/issues/1)\n\n**[#1](https://github.com/u/r/issues/1)**",
+		},
+		{
+			what:  "same URL in code span in previous paragraph",
+			input: "`https://github.com/u/r/issues/1`\n\n- https://github.com/u/r/issues/1",
+			want:  "`https://github.com/u/r/issues/1`\n\n- [#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "same URL in text and bold text",
+			input: "https://github.com/u/r/issues/1 **https://github.com/u/r/issues/1**",
+			want:  "[#1](https://github.com/u/r/issues/1) **[#1](https://github.com/u/r/issues/1)**",
+		},
+		{
+			what:  "definition list syntax",
+			input: "Term #1\n: Description by @foo\n: GH-2",
+			want:  "Term [#1](https://github.com/u/r/issues/1)\n: Description by [@foo](https://github.com/foo)\n: [GH-2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:  "references escaped with backslash",
+			input: `\#1 \@foo #3`,
+			want:  `\#1 \@foo [#3](https://github.com/u/r/issues/3)`,
+		},
+		{
+			// GFM does not recognize the URL as autolink since it does not follow a whitespace
+			what:  "URL after backslash",
+			input: `\https://github.com/u/r/issues/2`,
+			want:  `\https://github.com/u/r/issues/2`,
+		},
+		{
+			what:  "backslash before commit hash and slug is not escape",
+			input: `\41608e5f4109208a6ab995c58266554e6071c5b2 \foo/bar#1`,
+			want:  `\\[` + "`41608e5f41`" + `](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) \\[foo/bar#1](https://github.com/foo/bar/issues/1)`,
+		},
+		{
+			what:  "references after escaped backslash",
+			input: `\\#1 \\@foo`,
+			want:  `\\[#1](https://github.com/u/r/issues/1) \\[@foo](https://github.com/foo)`,
+		},
+		{
+			what:  "hash followed by non-numeric characters",
+			input: "#L123 #section #abc #1 @foo",
+			want:  "#L123 #section #abc [#1](https://github.com/u/r/issues/1) [@foo](https://github.com/foo)",
+		},
+		{
+			what:  "issue reference after hash followed by non-numeric characters",
+			input: "#abc-#2 #L#3",
+			want:  "#abc-[#2](https://github.com/u/r/issues/2) #L#3",
+		},
+		{
+			what:  "issue reference after BOM",
+			input: "\xef\xbb\xbf#123",
+			want:  "\xef\xbb\xbf[#123](https://github.com/u/r/issues/123)",
+		},
+		{
+			what:  "URL after BOM",
+			input: "\xef\xbb\xbfhttps://github.com/u/r/issues/1 @foo",
+			want:  "\xef\xbb\xbf[#1](https://github.com/u/r/issues/1) [@foo](https://github.com/foo)",
+		},
+		{
+			what:  "BOM without references",
+			input: "\xef\xbb\xbfhello",
+			want:  "\xef\xbb\xbfhello",
+		},
+		{
+			what:  "BOM not at start",
+			input: "#1 \xef\xbb\xbf#2",
+			want:  "[#1](https://github.com/u/r/issues/1) \xef\xbb\xbf[#2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:  "references separated by non-breaking spaces",
+			input: "fix\u00a0#123\u00a0by\u00a0@foo\u00a041608e5f4109208a6ab995c58266554e6071c5b2\u00a0GH-1\u00a0more",
+			want:  "fix\u00a0[#123](https://github.com/u/r/issues/123)\u00a0by\u00a0[@foo](https://github.com/foo)\u00a0[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)\u00a0[GH-1](https://github.com/u/r/issues/1)\u00a0more",
+		},
+		{
+			what:  "non-breaking space after sigil",
+			input: "#\u00a0123 @\u00a0foo",
+			want:  "#\u00a0123 @\u00a0foo",
+		},
+		{
+			what:  "issue reference with repository slug",
+			input: "foo/bar#1 (foo/bar#2) u/r#3 u/other#4",
+			want:  "[foo/bar#1](https://github.com/foo/bar/issues/1) ([foo/bar#2](https://github.com/foo/bar/issues/2)) [#3](https://github.com/u/r/issues/3) [other#4](https://github.com/u/other/issues/4)",
+		},
+		{
+			what:  "issue reference with repository slug including special characters",
+			input: "foo-1/bar_2.js#1",
+			want:  "[foo-1/bar_2.js#1](https://github.com/foo-1/bar_2.js/issues/1)",
+		},
+		{
+			what:  "issue reference with invalid repository slug",
+			input: "../x#1 a//b#1 a/b/c#1 /b#1 -a/b#1 a-/b#1 a_b/c#1",
+			want:  "../x#1 a//b#1 a/b/c#1 /b#1 -a/b#1 a-/b#1 a_b/c#1",
+		},
+		{
+			what:  "issue reference with too long owner name",
+			input: strings.Repeat("x", 40) + "/b#1 " + strings.Repeat("x", 39) + "/b#2",
+			want:  strings.Repeat("x", 40) + "/b#1 [" + strings.Repeat("x", 39) + "/b#2](https://github.com/" + strings.Repeat("x", 39) + "/b/issues/2)",
+		},
+		{
+			what:  "issue reference with repository slug not followed by number",
+			input: "foo/bar#abc foo/bar# foo/bar#1a",
+			want:  "foo/bar#abc foo/bar# foo/bar#1a",
+		},
+		{
+			what:  "file path with line number",
+			input: "path/to/file.go#10",
+			want:  "path/to/file.go#10",
+		},
+		{
+			what:  "commit reference with invalid repository slug",
+			input: "../x@41608e5 a_b/c@41608e5 -a/b@41608e5 " + strings.Repeat("x", 40) + "/b@41608e5",
+			want:  "../x@41608e5 a_b/c@41608e5 -a/b@41608e5 " + strings.Repeat("x", 40) + "/b@41608e5",
+		},
+		{
+			what:  "URL with unbalanced parenthesis",
+			input: "https://github.com/u/r/issues/1#(",
+			want:  "https://github.com/u/r/issues/1#(",
+		},
+		{
+			what:  "URLs with invalid repository slug",
+			input: "https://github.com/a/../issues/1 https://github.com/u/(/issues/2",
+			want:  "https://github.com/a/../issues/1 https://github.com/u/(/issues/2",
+		},
+		{
+			what:  "issue number across soft line break",
+			input: "foo #\n123",
+			want:  "foo #\n123",
+		},
+		{
+			what:  "issue followed by soft line break",
+			input: "#123\nmore",
+			want:  "[#123](https://github.com/u/r/issues/123)\nmore",
+		},
+		{
+			what:  "issue number split by soft line break",
+			input: "#12\n3",
+			want:  "[#12](https://github.com/u/r/issues/12)\n3",
+		},
+		{
+			what:  "issue number split by hard line break",
+			input: "#12  \n3 #45\\\n6",
+			want:  "[#12](https://github.com/u/r/issues/12)  \n3 [#45](https://github.com/u/r/issues/45)\\\n6",
+		},
+		{
+			what:  "user name across soft line break",
+			input: "@\nfoo @bar\nbaz",
+			want:  "@\nfoo [@bar](https://github.com/bar)\nbaz",
+		},
+		{
+			what:  "commit sha split by soft line break",
+			input: "41608e5f4109208a6ab995c5826\n6554e6071c5b2",
+			want:  "41608e5f4109208a6ab995c5826\n6554e6071c5b2",
+		},
+		{
+			what:  "external reference across soft line break",
+			input: "GH-\n12",
+			want:  "GH-\n12",
+		},
+		{
+			what:  "issue in table cell",
+			input: "| a | b |\n|---|---|\n| fix | #123 |",
+			want:  "| a | b |\n|---|---|\n| fix | [#123](https://github.com/u/r/issues/123) |",
+		},
+		{
+			what:  "references in table header and cells",
+			input: "| #1 | @foo |\n|-|-|\n|#2|  41608e5f4109208a6ab995c58266554e6071c5b2  |",
+			want:  "| [#1](https://github.com/u/r/issues/1) | [@foo](https://github.com/foo) |\n|-|-|\n|[#2](https://github.com/u/r/issues/2)|  [`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)  |",
+		},
+		{
+			what:  "issue URL in table cell",
+			input: "| a |\n|---|\n| https://github.com/u/r/issues/1 |",
+			want:  "| a |\n|---|\n| [#1](https://github.com/u/r/issues/1) |",
+		},
+		{
+			what:  "escaped pipe and code span in table cell",
+			input: "| a |\n|---|\n| `#1` \\| #2 |",
+			want:  "| a |\n|---|\n| `#1` \\| [#2](https://github.com/u/r/issues/2) |",
+		},
 		{
 			what:    "issue with GHE URL",
 			input:   "#123",
@@ -306,6 +876,24 @@ func TestLinkRefs(t *testing.T) {
 			want:    "[@foo](https://github.some-company.com/foo)",
 			repoURL: "https://github.some-company.com/user/repo",
 		},
+		{
+			what:    "references with port in repository URL",
+			input:   "#1 @foo GH-2 41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:    "[#1](https://git.example.com:8443/o/r/issues/1) [@foo](https://git.example.com:8443/foo) [GH-2](https://git.example.com:8443/o/r/issues/2) [`41608e5f41`](https://git.example.com:8443/o/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+			repoURL: "https://git.example.com:8443/o/r",
+		},
+		{
+			what:    "URLs with port in repository URL",
+			input:   "https://git.example.com:8443/o/r/issues/1 https://git.example.com:8443/x/y/pull/2 https://git.example.com:8443/o/r/commit/41608e5f41",
+			want:    "[#1](https://git.example.com:8443/o/r/issues/1) [x/y#2](https://git.example.com:8443/x/y/pull/2) [`41608e5f41`](https://git.example.com:8443/o/r/commit/41608e5f41)",
+			repoURL: "https://git.example.com:8443/o/r",
+		},
+		{
+			what:    "URLs with different port from repository URL",
+			input:   "https://git.example.com/o/r/issues/1 https://git.example.com:84430/o/r/issues/2",
+			want:    "https://git.example.com/o/r/issues/1 https://git.example.com:84430/o/r/issues/2",
+			repoURL: "https://git.example.com:8443/o/r",
+		},
 		{
 			what:  "slash after user name",
 			input: "@foo/",
@@ -411,6 +999,56 @@ func TestLinkRefs(t *testing.T) {
 			input: "z41608e5f",
 			want:  "z41608e5f",
 		},
+		{
+			what:  "commit sha with repository slug",
+			input: "foo/bar@41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[foo/bar@`41608e5f41`](https://github.com/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "short commit sha with repository slug",
+			input: "see foo/bar.js@41608e5 for details",
+			want:  "see [foo/bar.js@`41608e5`](https://github.com/foo/bar.js/commit/41608e5) for details",
+		},
+		{
+			what:  "commit sha with current repository slug",
+			input: "u/r@41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "commit sha with slug of repository owned by the same owner",
+			input: "u/other@41608e5",
+			want:  "[other@`41608e5`](https://github.com/u/other/commit/41608e5)",
+		},
+		{
+			what:  "commit sha with slug of repository whose owner has the same prefix",
+			input: "uu/r@41608e5",
+			want:  "[uu/r@`41608e5`](https://github.com/uu/r/commit/41608e5)",
+		},
+		{
+			what:  "too short commit sha with repository slug",
+			input: "foo/bar@41608e",
+			want:  "foo/bar@41608e",
+		},
+		{
+			what:  "commit sha with repository slug followed by alphabets",
+			input: "foo/bar@41608e5z",
+			want:  "foo/bar@41608e5z",
+		},
+		{
+			what:  "commit sha with repository slug longer than 40 characters",
+			input: "foo/bar@41608e5f4109208a6ab995c58266554e6071c5b2a",
+			want:  "foo/bar@41608e5f4109208a6ab995c58266554e6071c5b2a",
+		},
+		{
+			what:  "commit sha with invalid repository slug",
+			input: "foo_x/bar@41608e5 foo/bar/baz@41608e5 /bar@41608e5",
+			want:  "foo_x/bar@41608e5 foo/bar/baz@41608e5 /bar@41608e5",
+		},
+		{
+			what:  "user follows repository slug",
+			input: "foo/bar@baz",
+			want:  "foo/bar@baz",
+		},
 		{
 			what:  "non-GitHub URL",
 			input: "https://example.com",
@@ -522,7 +1160,7 @@ func TestLinkRefs(t *testing.T) {
 		{
 			what:  "PR URL changed files",
 			input: "changed files are https://github.com/foo/bar/pull/123/files",
-			want:  "changed files are https://github.com/foo/bar/pull/123/files",
+			want:  "changed files are [foo/bar#123 (files)](https://github.com/foo/bar/pull/123/files)",
 		},
 		{
 			what:  "issue URL with comment hash link",
@@ -550,18 +1188,243 @@ func TestLinkRefs(t *testing.T) {
 			want:  "the PR review is [#123 (review)](https://github.com/u/r/pull/123#pullrequestreview-1212591132)",
 		},
 		{
-			what:  "underscores in repository name of PR URL",
-			input: "the PR is https://github.com/foo/a_b_c_d/pull/123!",
-			want:  "the PR is [foo/a_b_c_d#123](https://github.com/foo/a_b_c_d/pull/123)!",
+			what:  "PR files URL",
+			input: "see https://github.com/u/r/pull/15/files",
+			want:  "see [#15 (files)](https://github.com/u/r/pull/15/files)",
 		},
 		{
-			what:  "GitHub external reference in text",
-			input: "This is GH-123 link",
-			want:  "This is [GH-123](https://github.com/u/r/issues/123) link",
+			what:  "PR commits URL",
+			input: "see https://github.com/u/r/pull/15/commits",
+			want:  "see [#15 (commits)](https://github.com/u/r/pull/15/commits)",
 		},
 		{
-			what:  "GitHub external reference only",
-			input: "GH-123",
+			what:  "PR checks URL",
+			input: "see https://github.com/u/r/pull/15/checks",
+			want:  "see [#15 (checks)](https://github.com/u/r/pull/15/checks)",
+		},
+		{
+			what:  "PR files URL with trailing slash",
+			input: "see https://github.com/u/r/pull/15/files/",
+			want:  "see [#15 (files)](https://github.com/u/r/pull/15/files/)",
+		},
+		{
+			what:  "PR files URL with hash",
+			input: "see https://github.com/u/r/pull/15/files#diff-a1b2c3",
+			want:  "see [#15 (files)](https://github.com/u/r/pull/15/files#diff-a1b2c3)",
+		},
+		{
+			what:  "PR files URL in other repository",
+			input: "see https://github.com/foo/bar/pull/15/files",
+			want:  "see [foo/bar#15 (files)](https://github.com/foo/bar/pull/15/files)",
+		},
+		{
+			what:  "issue URL with sub-page",
+			input: "see https://github.com/u/r/issues/15/files",
+			want:  "see https://github.com/u/r/issues/15/files",
+		},
+		{
+			what:  "PR URL with unknown sub-page",
+			input: "see https://github.com/u/r/pull/15/foo",
+			want:  "see https://github.com/u/r/pull/15/foo",
+		},
+		{
+			what:  "issue URL in parentheses",
+			input: "(https://github.com/u/r/issues/1)",
+			want:  "([#1](https://github.com/u/r/issues/1))",
+		},
+		{
+			what:  "issue URL followed by period",
+			input: "see https://github.com/u/r/issues/1.",
+			want:  "see [#1](https://github.com/u/r/issues/1).",
+		},
+		{
+			what:  "URLs followed by punctuations",
+			input: "see https://github.com/u/r/issues/1, https://github.com/u/r/pull/2! https://github.com/u/r/issues/3?",
+			want:  "see [#1](https://github.com/u/r/issues/1), [#2](https://github.com/u/r/pull/2)! [#3](https://github.com/u/r/issues/3)?",
+		},
+		{
+			what:  "issue URL in parentheses followed by period",
+			input: "(see https://github.com/u/r/issues/1).",
+			want:  "(see [#1](https://github.com/u/r/issues/1)).",
+		},
+		{
+			what:  "commit URL in parentheses followed by period",
+			input: "(see https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2).",
+			want:  "(see [`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)).",
+		},
+		{
+			what:  "issue URL in nested parentheses followed by punctuations",
+			input: "((see https://github.com/u/r/issues/1)).!",
+			want:  "((see [#1](https://github.com/u/r/issues/1))).!",
+		},
+		{
+			what:  "issue URL at end of line",
+			input: "https://github.com/u/r/issues/1\nfoo",
+			want:  "[#1](https://github.com/u/r/issues/1)\nfoo",
+		},
+		{
+			what:  "issue URL at end of line with CRLF",
+			input: "https://github.com/u/r/issues/1\r\nfoo",
+			want:  "[#1](https://github.com/u/r/issues/1)\r\nfoo",
+		},
+		{
+			what:  "issue URL followed by hard line break",
+			input: "https://github.com/u/r/issues/1  \nfoo",
+			want:  "[#1](https://github.com/u/r/issues/1)  \nfoo",
+		},
+		{
+			what:  "issue URLs at end of lines",
+			input: "x https://github.com/u/r/issues/1\nhttps://github.com/u/r/pull/2\n#3",
+			want:  "x [#1](https://github.com/u/r/issues/1)\n[#2](https://github.com/u/r/pull/2)\n[#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "issue URL followed by ellipsis",
+			input: "https://github.com/u/r/issues/1... #2",
+			want:  "[#1](https://github.com/u/r/issues/1)... [#2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:  "references around commit URL",
+			input: "#1 fixed at https://github.com/u/r/commit/41608e5f41 by @foo. See #2",
+			want:  "[#1](https://github.com/u/r/issues/1) fixed at [`41608e5f41`](https://github.com/u/r/commit/41608e5f41) by [@foo](https://github.com/foo). See [#2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:  "references between multiple URLs",
+			input: "https://github.com/u/r/issues/1 #2 https://github.com/u/r/pull/3 @foo https://github.com/foo/bar/commit/41608e5f41",
+			want:  "[#1](https://github.com/u/r/issues/1) [#2](https://github.com/u/r/issues/2) [#3](https://github.com/u/r/pull/3) [@foo](https://github.com/foo) [foo/bar@`41608e5f41`](https://github.com/foo/bar/commit/41608e5f41)",
+		},
+		{
+			what:  "URL follows code span",
+			input: "`foo`https://github.com/u/r/issues/1",
+			want:  "`foo`https://github.com/u/r/issues/1",
+		},
+		{
+			what:  "release URL inside the repo",
+			input: "see https://github.com/u/r/releases/tag/v1.2.3.",
+			want:  "see [v1.2.3](https://github.com/u/r/releases/tag/v1.2.3).",
+		},
+		{
+			what:  "release URL outside the repo",
+			input: "see https://github.com/foo/bar/releases/tag/v1.2.3/",
+			want:  "see [foo/bar@v1.2.3](https://github.com/foo/bar/releases/tag/v1.2.3/)",
+		},
+		{
+			what:  "releases page URL",
+			input: "https://github.com/u/r/releases https://github.com/u/r/releases/latest",
+			want:  "https://github.com/u/r/releases https://github.com/u/r/releases/latest",
+		},
+		{
+			what:  "compare URL inside the repo",
+			input: "**Full Changelog**: https://github.com/u/r/compare/v1.0.0...v1.1.0",
+			want:  "**Full Changelog**: [v1.0.0...v1.1.0](https://github.com/u/r/compare/v1.0.0...v1.1.0)",
+		},
+		{
+			what:  "compare URL outside the repo",
+			input: "https://github.com/foo/bar/compare/main..dev",
+			want:  "[foo/bar@main..dev](https://github.com/foo/bar/compare/main..dev)",
+		},
+		{
+			what:  "compare URL without range",
+			input: "https://github.com/u/r/compare/v1.0.0 https://github.com/u/r/compare",
+			want:  "https://github.com/u/r/compare/v1.0.0 https://github.com/u/r/compare",
+		},
+		{
+			what:  "security advisory URL inside the repo",
+			input: "fix https://github.com/u/r/security/advisories/GHSA-2jx2-76rc-2v7v.",
+			want:  "fix [GHSA-2jx2-76rc-2v7v](https://github.com/u/r/security/advisories/GHSA-2jx2-76rc-2v7v).",
+		},
+		{
+			what:  "security advisory URL outside the repo",
+			input: "https://github.com/foo/bar/security/advisories/GHSA-xvch-5gv4-984h/",
+			want:  "[foo/bar GHSA-xvch-5gv4-984h](https://github.com/foo/bar/security/advisories/GHSA-xvch-5gv4-984h/)",
+		},
+		{
+			what:  "malformed security advisory IDs",
+			input: "https://github.com/u/r/security/advisories/GHSA-2jx2-76rc https://github.com/u/r/security/advisories/GHSA-1abc-76rc-2v7v https://github.com/u/r/security/advisories/GHSA-2JX2-76RC-2V7V https://github.com/u/r/security/advisories/GHSA-2jx2-76rc-2v7v7",
+			want:  "https://github.com/u/r/security/advisories/GHSA-2jx2-76rc https://github.com/u/r/security/advisories/GHSA-1abc-76rc-2v7v https://github.com/u/r/security/advisories/GHSA-2JX2-76RC-2V7V https://github.com/u/r/security/advisories/GHSA-2jx2-76rc-2v7v7",
+		},
+		{
+			what:  "security advisories page URL",
+			input: "https://github.com/u/r/security/advisories https://github.com/u/r/security/advisories/new",
+			want:  "https://github.com/u/r/security/advisories https://github.com/u/r/security/advisories/new",
+		},
+		{
+			what:  "permalink URL inside the repo",
+			input: "see https://github.com/u/r/blob/41608e5f4109208a6ab995c58266554e6071c5b2/src/main.go#L10-L20",
+			want:  "see [src/main.go#L10-L20](https://github.com/u/r/blob/41608e5f4109208a6ab995c58266554e6071c5b2/src/main.go#L10-L20)",
+		},
+		{
+			what:  "permalink URL to file at root with single line",
+			input: "https://github.com/u/r/blob/41608e5/main.go#L3",
+			want:  "[main.go#L3](https://github.com/u/r/blob/41608e5/main.go#L3)",
+		},
+		{
+			what:  "permalink URL without line anchor",
+			input: "https://github.com/u/r/blob/41608e5/README.md",
+			want:  "[README.md](https://github.com/u/r/blob/41608e5/README.md)",
+		},
+		{
+			what:  "permalink URL outside the repo",
+			input: "https://github.com/foo/bar/blob/41608e5/a/b.go#L1-L2",
+			want:  "[foo/bar a/b.go#L1-L2](https://github.com/foo/bar/blob/41608e5/a/b.go#L1-L2)",
+		},
+		{
+			what:  "blob URL at branch",
+			input: "https://github.com/u/r/blob/main/README.md",
+			want:  "https://github.com/u/r/blob/main/README.md",
+		},
+		{
+			what:  "permalink URL with non-line anchor",
+			input: "https://github.com/u/r/blob/41608e5/README.md#usage",
+			want:  "https://github.com/u/r/blob/41608e5/README.md#usage",
+		},
+		{
+			what:  "permalink URL to directory",
+			input: "https://github.com/u/r/blob/41608e5/ https://github.com/u/r/blob/41608e5/src/",
+			want:  "https://github.com/u/r/blob/41608e5/ https://github.com/u/r/blob/41608e5/src/",
+		},
+		{
+			what:  "URLs in repository whose name starts with the repository name",
+			input: "https://github.com/u/r2/issues/1 https://github.com/u/r-foo/commit/41608e5f41",
+			want:  "[u/r2#1](https://github.com/u/r2/issues/1) [u/r-foo@`41608e5f41`](https://github.com/u/r-foo/commit/41608e5f41)",
+		},
+		{
+			what:  "issue URL with trailing slash",
+			input: "https://github.com/u/r/issues/11/",
+			want:  "[#11](https://github.com/u/r/issues/11/)",
+		},
+		{
+			what:  "PR URL with trailing slash",
+			input: "https://github.com/u/r/pull/12/ and https://github.com/foo/bar/pull/13/",
+			want:  "[#12](https://github.com/u/r/pull/12/) and [foo/bar#13](https://github.com/foo/bar/pull/13/)",
+		},
+		{
+			what:  "issue URL with trailing slash and comment hash link",
+			input: "https://github.com/u/r/issues/11/#issuecomment-1346614286",
+			want:  "[#11 (comment)](https://github.com/u/r/issues/11/#issuecomment-1346614286)",
+		},
+		{
+			what:  "commit URL with trailing slash",
+			input: "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2/",
+			want:  "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2/)",
+		},
+		{
+			what:  "issue URL with multiple trailing slashes",
+			input: "https://github.com/u/r/issues/11//",
+			want:  "https://github.com/u/r/issues/11//",
+		},
+		{
+			what:  "underscores in repository name of PR URL",
+			input: "the PR is https://github.com/foo/a_b_c_d/pull/123!",
+			want:  "the PR is [foo/a_b_c_d#123](https://github.com/foo/a_b_c_d/pull/123)!",
+		},
+		{
+			what:  "GitHub external reference in text",
+			input: "This is GH-123 link",
+			want:  "This is [GH-123](https://github.com/u/r/issues/123) link",
+		},
+		{
+			what:  "GitHub external reference only",
+			input: "GH-123",
 			want:  "[GH-123](https://github.com/u/r/issues/123)",
 		},
 		{
@@ -628,6 +1491,16 @@ func TestLinkCustomReferences(t *testing.T) {
 			input: "xFOO-123 is not linked",
 			want:  "xFOO-123 is not linked",
 		},
+		{
+			what:  "references escaped with backslash",
+			input: `\FOO-1 \BAR-a \GH-2 FOO-3`,
+			want:  `\FOO-1 \BAR-a \GH-2 [FOO-3](https://example.com/foo/3)`,
+		},
+		{
+			what:  "reference after escaped backslash",
+			input: `\\FOO-1 a\GH-2`,
+			want:  `\\[FOO-1](https://example.com/foo/1) a\GH-2`,
+		},
 		{
 			what:  "alphanumeric reference",
 			input: "ref BAR-abC123 is alphanumeric",
@@ -638,6 +1511,16 @@ func TestLinkCustomReferences(t *testing.T) {
 			input: "ref BAR-abc123あ is linked",
 			want:  "ref [BAR-abc123](https://example.com/bar/abc123)あ is linked",
 		},
+		{
+			what:  "multiple kinds of references",
+			input: "BAR-a GH-1 FOO-2",
+			want:  "[BAR-a](https://example.com/bar/a) [GH-1](https://github.com/u/r/issues/1) [FOO-2](https://example.com/foo/2)",
+		},
+		{
+			what:  "prefix with special characters",
+			input: "ref A.B-3 and AxB-4",
+			want:  "ref [A.B-3](https://example.com/ab/3) and AxB-4",
+		},
 		{
 			what:  "alphanumeric ref with underscores",
 			input: "ref BAR-A_1_ is linked",
@@ -650,6 +1533,65 @@ func TestLinkCustomReferences(t *testing.T) {
 			l := NewReflinker("https://github.com/u/r")
 			l.AddExtRef("FOO-", "https://example.com/foo/<num>", false)
 			l.AddExtRef("BAR-", "https://example.com/bar/<num>", true)
+			l.AddExtRef("A.B-", "https://example.com/ab/<num>", false)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkExtRefOpts(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "boundary-optional reference inside word",
+			input: "see refABC-123",
+			want:  "see ref[ABC-123](https://example.com/abc/123)",
+		},
+		{
+			what:  "boundary-optional reference at word boundary",
+			input: "see ABC-123",
+			want:  "see [ABC-123](https://example.com/abc/123)",
+		},
+		{
+			what:  "boundary-required reference inside word",
+			input: "see refDEF-123",
+			want:  "see refDEF-123",
+		},
+		{
+			what:  "boundary-required reference at word boundary",
+			input: "see DEF-123",
+			want:  "see [DEF-123](https://example.com/def/123)",
+		},
+		{
+			what:  "prefix starting with non-word character after space",
+			input: "see #X12",
+			want:  "see [#X12](https://example.com/x/12)",
+		},
+		{
+			what:  "prefix starting with non-word character inside word",
+			input: "see foo#X12",
+			want:  "see foo#X12",
+		},
+		{
+			what:  "alphanumeric boundary-optional reference",
+			input: "refGHI-a1b",
+			want:  "ref[GHI-a1b](https://example.com/ghi/a1b)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.AddExtRefOpts("ABC-", "https://example.com/abc/<num>", ExtRefOpts{})
+			l.AddExtRefOpts("DEF-", "https://example.com/def/<num>", ExtRefOpts{RequireBoundary: true})
+			l.AddExtRefOpts("#X", "https://example.com/x/<num>", ExtRefOpts{RequireBoundary: true})
+			l.AddExtRefOpts("GHI-", "https://example.com/ghi/<num>", ExtRefOpts{Alphanumeric: true})
 			have := l.Link(tc.input)
 			if have != tc.want {
 				t.Fatalf("wanted %q but got %q", tc.want, have)
@@ -657,3 +1599,3247 @@ func TestLinkCustomReferences(t *testing.T) {
 		})
 	}
 }
+
+func TestLinkGolden(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "reflink", "release_body.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := string(b)
+	b, err = os.ReadFile(filepath.Join("testdata", "reflink", "release_body.golden.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(b)
+
+	l := NewReflinker("https://github.com/u/r")
+	have := l.Link(input)
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Fatalf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	// Every reference is linked exactly once. References in code and links are not linked.
+	texts := []string{
+		"@alice",
+		"@bob",
+		"#10",
+		"GH-11",
+		"#12",
+		"https://github.com/u/r/pull/13",
+		"foo/bar#14",
+		"41608e5f4109208a6ab995c58266554e6071c5b2",
+		"foo/bar@93e1af6ec49d23397baba466fba1e89cc8b6de39",
+		"https://github.com/u/r/commit/93e1af6ec49d23397baba466fba1e89cc8b6de39",
+		"@carol",
+		"https://github.com/foo/bar/issues/15",
+		"#19",
+		"@grace",
+		"https://github.com/u/r/compare/v1.0.0...v1.1.0",
+	}
+	var refs []string
+	for _, r := range l.Refs(input) {
+		refs = append(refs, r.Text)
+	}
+	if diff := cmp.Diff(texts, refs); diff != "" {
+		t.Fatalf("references mismatch (-want +got):\n%s", diff)
+	}
+
+	if again := l.Link(have); again != have {
+		t.Fatalf("linking the output again changed it:\n%s", cmp.Diff(have, again))
+	}
+}
+
+func TestLinkGeneratedReleaseNotes(t *testing.T) {
+	// Release notes automatically generated by GitHub
+	input := `## What's Changed
+* Fix crash on empty input by @foo in https://github.com/u/r/pull/12
+* Bump actions/checkout from 3 to 4 by @dependabot in https://github.com/u/r/pull/13
+
+## New Contributors
+* @foo made their first contribution in https://github.com/u/r/pull/12
+
+**Full Changelog**: https://github.com/u/r/compare/v1.0.0...v1.1.0
+`
+	want := `## What's Changed
+* Fix crash on empty input by [@foo](https://github.com/foo) in [#12](https://github.com/u/r/pull/12)
+* Bump actions/checkout from 3 to 4 by [@dependabot](https://github.com/dependabot) in [#13](https://github.com/u/r/pull/13)
+
+## New Contributors
+* [@foo](https://github.com/foo) made their first contribution in [#12](https://github.com/u/r/pull/12)
+
+**Full Changelog**: [v1.0.0...v1.1.0](https://github.com/u/r/compare/v1.0.0...v1.1.0)
+`
+	l := NewReflinker("https://github.com/u/r")
+	if have := l.Link(input); have != want {
+		t.Fatal(cmp.Diff(want, have))
+	}
+}
+
+func TestLinkURLOwnerWithUnderscore(t *testing.T) {
+	// Owner names of Enterprise Managed Users on GitHub Enterprise contain '_'
+	l := NewReflinker("https://ghe.example.com/corp_emu/r")
+	input := "https://ghe.example.com/corp_emu/r/issues/1 https://ghe.example.com/corp_emu/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2 https://ghe.example.com/corp_emu/other/pull/2"
+	want := "[#1](https://ghe.example.com/corp_emu/r/issues/1) [`41608e5f41`](https://ghe.example.com/corp_emu/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) [corp_emu/other#2](https://ghe.example.com/corp_emu/other/pull/2)"
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkIdempotent(t *testing.T) {
+	input := `Issue #123 and GH-456 by @foo
+Commit 41608e5f4109208a6ab995c58266554e6071c5b2 and foo/bar@41608e5
+Custom FOO-789 and BAR-abc
+
+- https://github.com/u/r/issues/1
+- https://github.com/u/r/pull/2#issuecomment-1346614286
+- https://github.com/foo/bar/pull/3#pullrequestreview-1212591132
+- https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2
+- https://github.com/foo/bar/commit/41608e5f41
+- **https://github.com/u/r/issues/4**
+- <@foo> and <#5>
+`
+
+	l := NewReflinker("https://github.com/u/r")
+	l.AddExtRef("FOO-", "https://example.com/foo/<num>", false)
+	l.AddExtRef("BAR-", "https://example.com/bar/<num>", true)
+
+	once := l.Link(input)
+	if once == input {
+		t.Fatalf("nothing was linked: %q", once)
+	}
+	twice := l.Link(once)
+	if once != twice {
+		t.Fatalf("linking the output again changed it:\n%s", cmp.Diff(once, twice))
+	}
+
+	for _, input := range []string{
+		"#1#2",
+		"#1@foo",
+		"@foo@bar",
+		"GH-1#2",
+		"41608e5f4109208a6ab995c58266554e6071c5b2#3",
+		"foo/bar#1#2",
+		"#1/foo#2",
+		"[foo]#1",
+		"[foo](https://example.com)#1",
+		"[#1](https://example.com)#2",
+		"[`41608e5`](https://example.com)#2",
+		"u/foo#1#2 u/foo@41608e5#3",
+		"`foo/bar@41608e5",
+		"`foo` `` 41608e5f4109208a6ab995c58266554e6071c5b2",
+		"` https://github.com/u/r/commit/41608e5f41",
+		"[`](https://example.com) 41608e5f4109208a6ab995c58266554e6071c5b2",
+		"_#0__!#0_",
+		"_#0\x9a__!#0_",
+		"*@foo**!@bar*",
+	} {
+		once := l.Link(input)
+		if twice := l.Link(once); once != twice {
+			t.Errorf("linking the output of %q again changed it:\n%s", input, cmp.Diff(once, twice))
+		}
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "inline link",
+			input: "see [#123](https://github.com/u/r/issues/123) for details",
+			want:  "see #123 for details",
+		},
+		{
+			what:  "multiple links",
+			input: "[@foo](https://github.com/foo) [`41608e5f41`](https://github.com/u/r/commit/41608e5f41)",
+			want:  "@foo `41608e5f41`",
+		},
+		{
+			what:  "link with image label",
+			input: "[![img](https://example.com/a.png)](https://example.com) [**#1** ![img](https://example.com/b.png)](https://example.com)",
+			want:  "[![img](https://example.com/a.png)](https://example.com) [**#1** ![img](https://example.com/b.png)](https://example.com)",
+		},
+		{
+			what:  "link after BOM",
+			input: "\xef\xbb\xbf[#123](https://github.com/u/r/issues/123)",
+			want:  "\xef\xbb\xbf#123",
+		},
+		{
+			what:  "link with title",
+			input: `[foo](https://example.com "some title")`,
+			want:  "foo",
+		},
+		{
+			what:  "link with parens in URL",
+			input: "[foo](https://example.com/a_(b)) bar",
+			want:  "foo bar",
+		},
+		{
+			what:  "link with nested inline nodes",
+			input: "[**foo** _bar_](https://example.com)",
+			want:  "**foo** _bar_",
+		},
+		{
+			what:  "reference link",
+			input: "[foo][bar] and [bar]\n\n[bar]: https://example.com",
+			want:  "foo and bar\n\n[bar]: https://example.com",
+		},
+		{
+			what:  "link in list",
+			input: "- [a](https://example.com/a)\n- [b](https://example.com/b)",
+			want:  "- a\n- b",
+		},
+		{
+			what:  "references are not touched",
+			input: "#123 @foo 41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "#123 @foo 41608e5f4109208a6ab995c58266554e6071c5b2",
+		},
+		{
+			what:  "image is not touched",
+			input: "![#123](https://example.com/img.png)",
+			want:  "![#123](https://example.com/img.png)",
+		},
+		{
+			what:  "link in code span",
+			input: "`[a](https://example.com)`",
+			want:  "`[a](https://example.com)`",
+		},
+		{
+			what:  "empty label",
+			input: "[](https://example.com)",
+			want:  "[](https://example.com)",
+		},
+		{
+			what:  "empty",
+			input: "",
+			want:  "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			have := l.Unlink(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestUnlinkInverseOfLink(t *testing.T) {
+	input := "#123 by @foo at 41608e5f4109208a6ab995c58266554e6071c5b2"
+	l := NewReflinker("https://github.com/u/r")
+	have := l.Unlink(l.Link(input))
+	want := "#123 by @foo at `41608e5f41`"
+	if have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkMentionRequiresLeadingSpace(t *testing.T) {
+	tests := []struct {
+		input    string
+		disabled string
+		enabled  string
+	}{
+		{
+			input:    "(@foo)",
+			disabled: "([@foo](https://github.com/foo))",
+			enabled:  "(@foo)",
+		},
+		{
+			input:    "@foo",
+			disabled: "[@foo](https://github.com/foo)",
+			enabled:  "[@foo](https://github.com/foo)",
+		},
+		{
+			input:    "thanks @foo and\n@bar",
+			disabled: "thanks [@foo](https://github.com/foo) and\n[@bar](https://github.com/bar)",
+			enabled:  "thanks [@foo](https://github.com/foo) and\n[@bar](https://github.com/bar)",
+		},
+		{
+			input:    "- @foo",
+			disabled: "- [@foo](https://github.com/foo)",
+			enabled:  "- [@foo](https://github.com/foo)",
+		},
+		{
+			input:    "-@foo /@bar",
+			disabled: "-[@foo](https://github.com/foo) /[@bar](https://github.com/bar)",
+			enabled:  "-@foo /@bar",
+		},
+		{
+			input:    "foo@bar.com",
+			disabled: "foo@bar.com",
+			enabled:  "foo@bar.com",
+		},
+		{
+			input:    "#1 (@foo)",
+			disabled: "[#1](https://github.com/u/r/issues/1) ([@foo](https://github.com/foo))",
+			enabled:  "[#1](https://github.com/u/r/issues/1) (@foo)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			if have := l.Link(tc.input); have != tc.disabled {
+				t.Fatalf("wanted %q but got %q when disabled", tc.disabled, have)
+			}
+			l.SetMentionRequiresLeadingSpace(true)
+			if have := l.Link(tc.input); have != tc.enabled {
+				t.Fatalf("wanted %q but got %q when enabled", tc.enabled, have)
+			}
+		})
+	}
+}
+
+func TestLinkTo(t *testing.T) {
+	inputs := []string{
+		"",
+		"nothing to link",
+		"#1 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 GH-2",
+		"- https://github.com/u/r/issues/1\n- https://github.com/foo/bar/commit/41608e5f41\n\n```\n#3\n```",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			want := l.Link(input)
+			var b bytes.Buffer
+			if err := l.LinkTo(&b, []byte(input)); err != nil {
+				t.Fatal(err)
+			}
+			if have := b.String(); have != want {
+				t.Fatalf("wanted %q but got %q", want, have)
+			}
+		})
+	}
+}
+
+type errorWriter struct{}
+
+func (w errorWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("dummy error")
+}
+
+func TestLinkToWriteError(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	err := l.LinkTo(errorWriter{}, []byte("#1"))
+	if err == nil || err.Error() != "dummy error" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// recordWriter records the output size at each write so that tests can check when progress is
+// reported.
+type recordWriter struct {
+	out    bytes.Buffer
+	events []string
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	w.events = append(w.events, fmt.Sprintf("write %q", b))
+	return w.out.Write(b)
+}
+
+func TestLinkStream(t *testing.T) {
+	input := "fix #1 by @foo\n\nsee `#2`"
+	l := NewReflinker("https://github.com/u/r")
+
+	var w recordWriter
+	err := l.LinkStream(strings.NewReader(input), &w, func(n int) {
+		w.events = append(w.events, fmt.Sprintf("progress %d", n))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := w.out.String(), l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+	want := []string{
+		`write "fix "`,
+		`write "[#1](https://github.com/u/r/issues/1)"`,
+		"progress 6",
+		`write " by "`,
+		`write "[@foo](https://github.com/foo)"`,
+		"progress 14",
+		"write \"\\n\\nsee `#2`\"",
+		"progress 24",
+	}
+	if diff := cmp.Diff(want, w.events); diff != "" {
+		t.Fatalf("events mismatch (-want +got):\n%s", diff)
+	}
+
+	// BOM is counted as input bytes
+	var b bytes.Buffer
+	var done []int
+	if err := l.LinkStream(strings.NewReader("\xef\xbb\xbf#1"), &b, func(n int) { done = append(done, n) }); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{5, 5}, done); diff != "" {
+		t.Fatalf("progress mismatch (-want +got):\n%s", diff)
+	}
+
+	// Progress callback is optional
+	b.Reset()
+	if err := l.LinkStream(strings.NewReader(input), &b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := b.String(), l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+
+	err = l.LinkStream(strings.NewReader(input), errorWriter{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "could not write linked markdown text: dummy error") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLinkForeignHosts(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "issue URL on foreign host",
+			input: "https://github.com/u/r/issues/1",
+			want:  "[github.com/u/r#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "PR URL with comment on foreign host",
+			input: "https://github.com/u/r/pull/2#issuecomment-1346614286",
+			want:  "[github.com/u/r#2 (comment)](https://github.com/u/r/pull/2#issuecomment-1346614286)",
+		},
+		{
+			what:  "commit URL on foreign host",
+			input: "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[github.com/u/r@`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "issue URL on host with the same prefix",
+			input: "http://github.company.com.example.org/u/r/issues/1",
+			want:  "[github.company.com.example.org/u/r#1](http://github.company.com.example.org/u/r/issues/1)",
+		},
+		{
+			what:  "issue URL on the same host",
+			input: "https://github.company.com/a/b/issues/1",
+			want:  "[#1](https://github.company.com/a/b/issues/1)",
+		},
+		{
+			what:  "foreign URL which is not issue nor commit",
+			input: "https://github.com/u/r/releases",
+			want:  "https://github.com/u/r/releases",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.company.com/a/b")
+			l.LinkForeignHosts(true)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkIssueRefRequireBoundaryBefore(t *testing.T) {
+	tests := []struct {
+		input    string
+		required string
+		loose    string
+	}{
+		{
+			input:    "v2#123",
+			required: "v2#123",
+			loose:    "v2[#123](https://github.com/u/r/issues/123)",
+		},
+		{
+			input:    "foo_#1 and 1#2",
+			required: "foo_#1 and 1#2",
+			loose:    "foo_[#1](https://github.com/u/r/issues/1) and 1[#2](https://github.com/u/r/issues/2)",
+		},
+		{
+			input:    "(#123)",
+			required: "([#123](https://github.com/u/r/issues/123))",
+			loose:    "([#123](https://github.com/u/r/issues/123))",
+		},
+		{
+			input:    "v2#foo #123a",
+			required: "v2#foo #123a",
+			loose:    "v2#foo #123a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			if have := l.Link(tc.input); have != tc.required {
+				t.Fatalf("wanted %q but got %q by default", tc.required, have)
+			}
+			l.SetIssueRefRequireBoundaryBefore(false)
+			if have := l.Link(tc.input); have != tc.loose {
+				t.Fatalf("wanted %q but got %q when boundary is not required", tc.loose, have)
+			}
+			l.SetIssueRefRequireBoundaryBefore(true)
+			if have := l.Link(tc.input); have != tc.required {
+				t.Fatalf("wanted %q but got %q when boundary is required", tc.required, have)
+			}
+		})
+	}
+}
+
+func TestLinkFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "CHANGELOG.md")
+	input := "- fix #1 by @foo\n- `#2` is not linked\n"
+	want := "- fix [#1](https://github.com/u/r/issues/1) by [@foo](https://github.com/foo)\n- `#2` is not linked\n"
+	if err := os.WriteFile(path, []byte(input), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewReflinker("https://github.com/u/r")
+
+	have, err := l.LinkFileDryRun(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != input {
+		t.Fatalf("file was modified by dry run: %q", b)
+	}
+
+	if err := l.LinkFile(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Fatalf("wanted %q but got %q", want, b)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Fatalf("file mode was not preserved: %v", info.Mode())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary file remains: %v", entries)
+	}
+}
+
+func TestLinkFileNotFound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "does-not-exist.md")
+	l := NewReflinker("https://github.com/u/r")
+	if err := l.LinkFile(path); err == nil {
+		t.Fatal("error did not occur")
+	}
+	if _, err := l.LinkFileDryRun(path); err == nil {
+		t.Fatal("error did not occur on dry run")
+	}
+}
+
+func TestLinkUserAllowlist(t *testing.T) {
+	tests := []struct {
+		what  string
+		names []string
+		input string
+		want  string
+	}{
+		{
+			what:  "only allowed users are linked",
+			names: []string{"foo", "bar"},
+			input: "@foo @baz @bar",
+			want:  "[@foo](https://github.com/foo) @baz [@bar](https://github.com/bar)",
+		},
+		{
+			what:  "case insensitive",
+			names: []string{"FooBar"},
+			input: "@foobar @FOOBAR",
+			want:  "[@foobar](https://github.com/foobar) [@FOOBAR](https://github.com/FOOBAR)",
+		},
+		{
+			what:  "names with at",
+			names: []string{"@foo"},
+			input: "@foo",
+			want:  "[@foo](https://github.com/foo)",
+		},
+		{
+			what:  "empty allowlist",
+			names: []string{},
+			input: "@foo #1",
+			want:  "@foo [#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "no allowlist",
+			names: nil,
+			input: "@foo @bar",
+			want:  "[@foo](https://github.com/foo) [@bar](https://github.com/bar)",
+		},
+		{
+			what:  "prefix of allowed user",
+			names: []string{"foo"},
+			input: "@foobar @fo",
+			want:  "@foobar @fo",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetUserAllowlist(tc.names)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkIssueDenylist(t *testing.T) {
+	tests := []struct {
+		what  string
+		nums  []int
+		input string
+		want  string
+	}{
+		{
+			what:  "denied issue is not linked",
+			nums:  []int{1},
+			input: "#1 priority fix for #12",
+			want:  "#1 priority fix for [#12](https://github.com/u/r/issues/12)",
+		},
+		{
+			what:  "multiple denied issues",
+			nums:  []int{1, 2},
+			input: "#1 #2 #3",
+			want:  "#1 #2 [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "scan continues after denied issue",
+			nums:  []int{1},
+			input: "#1,#2 (#1)",
+			want:  "#1,[#2](https://github.com/u/r/issues/2) (#1)",
+		},
+		{
+			what:  "leading zeros",
+			nums:  []int{1},
+			input: "#01",
+			want:  "#01",
+		},
+		{
+			what:  "external reference is not denied",
+			nums:  []int{1},
+			input: "GH-1",
+			want:  "[GH-1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "empty denylist",
+			nums:  nil,
+			input: "#1",
+			want:  "[#1](https://github.com/u/r/issues/1)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetIssueDenylist(tc.nums)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkRefValidator(t *testing.T) {
+	input := "#1 #2 @foo @bar 41608e5f4109208a6ab995c58266554e6071c5b2 GH-3 https://github.com/u/r/issues/4 https://github.com/u/r/commit/41608e5f41"
+	want := "#1 [#2](https://github.com/u/r/issues/2) @foo [@bar](https://github.com/bar) 41608e5f4109208a6ab995c58266554e6071c5b2 [GH-3](https://github.com/u/r/issues/3) https://github.com/u/r/issues/4 [`41608e5f41`](https://github.com/u/r/commit/41608e5f41)"
+
+	var refs []Ref
+	l := NewReflinker("https://github.com/u/r")
+	l.SetRefValidator(func(r Ref) bool {
+		refs = append(refs, r)
+		switch r.Kind {
+		case RefIssue:
+			return r.Text != "#1"
+		case RefUser:
+			return r.Text == "@bar"
+		case RefCommit, RefIssueURL:
+			return false
+		default:
+			return true
+		}
+	})
+
+	have := l.Link(input)
+	if have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+
+	wantRefs := []Ref{
+		{RefIssue, "#1", "https://github.com/u/r/issues/1"},
+		{RefIssue, "#2", "https://github.com/u/r/issues/2"},
+		{RefUser, "@foo", "https://github.com/foo"},
+		{RefUser, "@bar", "https://github.com/bar"},
+		{RefCommit, "41608e5f4109208a6ab995c58266554e6071c5b2", "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2"},
+		{RefExt, "GH-3", "https://github.com/u/r/issues/3"},
+		{RefIssueURL, "https://github.com/u/r/issues/4", "https://github.com/u/r/issues/4"},
+		{RefCommitURL, "https://github.com/u/r/commit/41608e5f41", "https://github.com/u/r/commit/41608e5f41"},
+	}
+	sort.Slice(refs, func(i, j int) bool { return strings.Index(input, refs[i].Text) < strings.Index(input, refs[j].Text) })
+	if !cmp.Equal(refs, wantRefs) {
+		t.Fatal(cmp.Diff(refs, wantRefs))
+	}
+
+	l.SetRefValidator(nil)
+	if have := l.Link("#1"); have != "[#1](https://github.com/u/r/issues/1)" {
+		t.Fatalf("validator was not removed: %q", have)
+	}
+}
+
+func TestLinkURLTransformer(t *testing.T) {
+	input := "#1 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 GH-2 https://github.com/u/r/issues/3 https://github.com/u/r/commit/41608e5f41"
+	want := "[#1](https://proxy.example.com/?issue=https://github.com/u/r/issues/1) " +
+		"[@foo](https://proxy.example.com/?user=https://github.com/foo) " +
+		"[`41608e5f41`](https://proxy.example.com/?commit=https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) " +
+		"[GH-2](https://proxy.example.com/?ext=https://github.com/u/r/issues/2) " +
+		"[#3](https://proxy.example.com/?issue-url=https://github.com/u/r/issues/3) " +
+		"[`41608e5f41`](https://proxy.example.com/?commit-url=https://github.com/u/r/commit/41608e5f41)"
+
+	var validated []string
+	l := NewReflinker("https://github.com/u/r")
+	l.SetRefValidator(func(r Ref) bool {
+		validated = append(validated, r.URL)
+		return true
+	})
+	l.SetURLTransformer(func(k RefKind, u string) string {
+		return "https://proxy.example.com/?" + string(k) + "=" + u
+	})
+
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+	for _, u := range validated {
+		if strings.HasPrefix(u, "https://proxy.example.com") {
+			t.Errorf("validator received transformed URL %q", u)
+		}
+	}
+
+	l.SetURLTransformer(nil)
+	if have := l.Link("#1"); have != "[#1](https://github.com/u/r/issues/1)" {
+		t.Fatalf("transformer was not removed: %q", have)
+	}
+}
+
+func TestLinkMaxTagNameLength(t *testing.T) {
+	tag := "v1.2.3-" + strings.Repeat("a", 93)
+	tests := []struct {
+		what  string
+		max   int
+		input string
+		want  string
+	}{
+		{
+			what:  "release URL",
+			max:   10,
+			input: "https://github.com/u/r/releases/tag/" + tag,
+			want:  "[v1.2.3-aaa…](https://github.com/u/r/releases/tag/" + tag + ")",
+		},
+		{
+			what:  "compare URL",
+			max:   10,
+			input: "https://github.com/u/r/compare/v1.2.2..." + tag,
+			want:  "[v1.2.2...v1.2.3-aaa…](https://github.com/u/r/compare/v1.2.2..." + tag + ")",
+		},
+		{
+			what:  "compare URL outside the repo",
+			max:   10,
+			input: "https://github.com/foo/bar/compare/" + tag + "..." + tag,
+			want:  "[foo/bar@v1.2.3-aaa…...v1.2.3-aaa…](https://github.com/foo/bar/compare/" + tag + "..." + tag + ")",
+		},
+		{
+			what:  "tag name not exceeding maximum length",
+			max:   100,
+			input: "https://github.com/u/r/releases/tag/" + tag,
+			want:  "[" + tag + "](https://github.com/u/r/releases/tag/" + tag + ")",
+		},
+		{
+			what:  "no truncation by default",
+			max:   0,
+			input: "https://github.com/u/r/releases/tag/" + tag,
+			want:  "[" + tag + "](https://github.com/u/r/releases/tag/" + tag + ")",
+		},
+	}
+
+	l := NewReflinker("https://github.com/u/r")
+	l.SetMaxTagNameLength(2)
+	if have := l.abbrevTag([]byte("リリース")); have != "リリ…" {
+		t.Errorf("multibyte tag name was not truncated by characters: %q", have)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetMaxTagNameLength(tc.max)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkFirstOccurrenceOnly(t *testing.T) {
+	input := "#1 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 GH-2\n\n" +
+		"- #1 #3 @foo @FOO @bar GH-2 41608e5f4109208a6ab995c58266554e6071c5b2\n" +
+		"- https://github.com/u/r/issues/1 https://github.com/u/r/issues/1"
+	want := "[#1](https://github.com/u/r/issues/1) [@foo](https://github.com/foo) [`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) [GH-2](https://github.com/u/r/issues/2)\n\n" +
+		"- #1 [#3](https://github.com/u/r/issues/3) @foo @FOO [@bar](https://github.com/bar) GH-2 41608e5f4109208a6ab995c58266554e6071c5b2\n" +
+		"- [#1](https://github.com/u/r/issues/1) https://github.com/u/r/issues/1"
+
+	l := NewReflinker("https://github.com/u/r")
+	l.SetLinkFirstOccurrenceOnly(true)
+	for i := 0; i < 2; i++ {
+		// Seen references must not be carried over to the next call
+		if have := l.Link(input); have != want {
+			t.Fatalf("wanted %q but got %q", want, have)
+		}
+	}
+
+	_, s := l.LinkWithReport(input)
+	if want := (LinkStats{Issues: 2, Users: 2, Commits: 1, URLs: 1, ExtRefs: 1}); s != want {
+		t.Fatalf("wanted %+v but got %+v", want, s)
+	}
+
+	l.SetLinkFirstOccurrenceOnly(false)
+	want = "[#1](https://github.com/u/r/issues/1) [#1](https://github.com/u/r/issues/1)"
+	if have := l.Link("#1 #1"); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkExtRefPriority(t *testing.T) {
+	tests := []struct {
+		what     string
+		input    string
+		builtin  string
+		priority string
+	}{
+		{
+			what:     "external reference in user reference",
+			input:    "@JIRA-1 and JIRA-2",
+			builtin:  "[@JIRA-1](https://github.com/JIRA-1) and [JIRA-2](https://jira.example.com/2)",
+			priority: "@[JIRA-1](https://jira.example.com/1) and [JIRA-2](https://jira.example.com/2)",
+		},
+		{
+			what:     "external reference in repository slug",
+			input:    "see JIRA-1/r#2",
+			builtin:  "see [JIRA-1/r#2](https://github.com/JIRA-1/r/issues/2)",
+			priority: "see [JIRA-1](https://jira.example.com/1)/r#2",
+		},
+		{
+			what:     "no overlap",
+			input:    "#1 @foo JIRA-3",
+			builtin:  "[#1](https://github.com/u/r/issues/1) [@foo](https://github.com/foo) [JIRA-3](https://jira.example.com/3)",
+			priority: "[#1](https://github.com/u/r/issues/1) [@foo](https://github.com/foo) [JIRA-3](https://jira.example.com/3)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.AddExtRef("JIRA-", "https://jira.example.com/<num>", false)
+			if have := l.Link(tc.input); have != tc.builtin {
+				t.Errorf("wanted %q but got %q by default", tc.builtin, have)
+			}
+			l.SetExtRefPriority(true)
+			if have := l.Link(tc.input); have != tc.priority {
+				t.Errorf("wanted %q but got %q with external reference priority", tc.priority, have)
+			}
+		})
+	}
+}
+
+func TestLinkOverlappedRefs(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	l.AddExtRef("JIRA-", "https://jira.example.com/<num>", false)
+	l.AddExtRef("v", "https://example.com/v/<num>", true)
+	l.LinkVersionTags("v")
+
+	input := "@JIRA-1 v1.2.3 JIRA-2/r#3"
+	want := "[@JIRA-1](https://github.com/JIRA-1) [v1.2.3](https://github.com/u/r/releases/tag/v1.2.3) [JIRA-2/r#3](https://github.com/JIRA-2/r/issues/3)"
+	if have := l.Link(input); have != want {
+		t.Errorf("Link: wanted %q but got %q", want, have)
+	}
+	if have := string(l.LinkBytes([]byte(input))); have != want {
+		t.Errorf("LinkBytes: wanted %q but got %q", want, have)
+	}
+	var b bytes.Buffer
+	if err := l.LinkTo(&b, []byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	if have := b.String(); have != want {
+		t.Errorf("LinkTo: wanted %q but got %q", want, have)
+	}
+
+	// Overlapped replacements are skipped on writing the output even if they remain
+	l = NewReflinker("https://github.com/u/r").start([]byte("FOO#123"))
+	l.reps = []replacement{
+		{start: 0, end: 7, kind: RefExt, label: "FOO#123", url: "https://example.com/123"},
+		{start: 3, end: 7, kind: RefIssue, label: "#123", url: "https://github.com/u/r/issues/123"},
+	}
+	want = "[FOO#123](https://example.com/123)"
+	if have := l.applyReplacements(); have != want {
+		t.Errorf("wanted %q but got %q", want, have)
+	}
+	if have := string(l.appendReplacements(nil)); have != want {
+		t.Errorf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkRegexpRefs(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "CVE identifier",
+			input: "fix CVE-2023-1234 reported at #1",
+			want:  "fix [CVE-2023-1234](https://nvd.nist.gov/vuln/detail/CVE-2023-1234) reported at [#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "submatches in URL",
+			input: "see RFC 9110, RFC-1",
+			want:  "see [RFC 9110](https://www.rfc-editor.org/rfc/rfc9110), [RFC-1](https://www.rfc-editor.org/rfc/rfc1)",
+		},
+		{
+			what:  "matched text is not detected as other references",
+			input: "TICKET#123 and #123 by @foo",
+			want:  "[TICKET#123](https://tickets.example.com/123) and [#123](https://github.com/u/r/issues/123) by [@foo](https://github.com/foo)",
+		},
+		{
+			what:  "precedence over external reference",
+			input: "GH-1234-9 GH-2",
+			want:  "[GH-1234-9](https://example.com/gh/1234/9) [GH-2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:  "precedence of pattern added first",
+			input: "TICKET#1-2",
+			want:  "[TICKET#1](https://tickets.example.com/1)-2",
+		},
+		{
+			what:  "references around multiple matches",
+			input: "#1 CVE-2023-1 #2 CVE-2023-2 #3",
+			want:  "[#1](https://github.com/u/r/issues/1) [CVE-2023-1](https://nvd.nist.gov/vuln/detail/CVE-2023-1) [#2](https://github.com/u/r/issues/2) [CVE-2023-2](https://nvd.nist.gov/vuln/detail/CVE-2023-2) [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "in code span",
+			input: "`CVE-2023-1234`",
+			want:  "`CVE-2023-1234`",
+		},
+		{
+			what:  "empty match is ignored",
+			input: "foo #1",
+			want:  "foo [#1](https://github.com/u/r/issues/1)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.AddRegexpRef(regexp.MustCompile(`\bCVE-\d{4}-\d+\b`), "https://nvd.nist.gov/vuln/detail/$0")
+			l.AddRegexpRef(regexp.MustCompile(`\bRFC[ -](\d+)\b`), "https://www.rfc-editor.org/rfc/rfc$1")
+			l.AddRegexpRef(regexp.MustCompile(`\bTICKET#(?P<id>\d+)`), "https://tickets.example.com/${id}")
+			l.AddRegexpRef(regexp.MustCompile(`\bTICKET#\d+-\d+`), "https://tickets.example.com/never")
+			l.AddRegexpRef(regexp.MustCompile(`\bGH-(\d+)-(\d+)\b`), "https://example.com/gh/$1/$2")
+			l.AddRegexpRef(regexp.MustCompile(`x*`), "https://example.com/empty")
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkRelativeLinks(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "issue reference",
+			input: "#123",
+			want:  "[#123](/u/r/issues/123)",
+		},
+		{
+			what:  "external reference",
+			input: "GH-123",
+			want:  "[GH-123](/u/r/issues/123)",
+		},
+		{
+			what:  "commit reference",
+			input: "41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[`41608e5f41`](/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "issue URL",
+			input: "https://github.com/u/r/pull/123#issuecomment-1346614286",
+			want:  "[#123 (comment)](/u/r/pull/123#issuecomment-1346614286)",
+		},
+		{
+			what:  "commit URL",
+			input: "https://github.com/u/r/commit/41608e5f41",
+			want:  "[`41608e5f41`](/u/r/commit/41608e5f41)",
+		},
+		{
+			what:  "compare URL",
+			input: "https://github.com/u/r/compare/v1.0.0...v1.1.0",
+			want:  "[v1.0.0...v1.1.0](/u/r/compare/v1.0.0...v1.1.0)",
+		},
+		{
+			what:  "release URL",
+			input: "https://github.com/u/r/releases/tag/v1.0.0",
+			want:  "[v1.0.0](/u/r/releases/tag/v1.0.0)",
+		},
+		{
+			what:  "user reference",
+			input: "@foo @u",
+			want:  "[@foo](https://github.com/foo) [@u](https://github.com/u)",
+		},
+		{
+			what:  "commit reference in other repository",
+			input: "foo/bar@41608e5",
+			want:  "[foo/bar@`41608e5`](https://github.com/foo/bar/commit/41608e5)",
+		},
+		{
+			what:  "URLs in other repositories",
+			input: "https://github.com/foo/bar/issues/1 https://github.com/u/r2/issues/2",
+			want:  "[foo/bar#1](https://github.com/foo/bar/issues/1) [u/r2#2](https://github.com/u/r2/issues/2)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetRelativeLinks(true)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l := NewReflinker("https://ghe.example.com/github/u/r")
+	l.SetRelativeLinks(true)
+	want := "[#1](/github/u/r/issues/1)"
+	if have := l.Link("#1"); have != want {
+		t.Fatalf("wanted %q but got %q for repository under subpath", want, have)
+	}
+}
+
+func TestLinkRelativeKinds(t *testing.T) {
+	tests := []struct {
+		what  string
+		kinds []RefKind
+		input string
+		want  string
+	}{
+		{
+			what:  "relative issues and absolute commits",
+			kinds: []RefKind{RefIssue, RefIssueURL},
+			input: "#1 https://github.com/u/r/pull/2 41608e5f4109208a6ab995c58266554e6071c5b2 https://github.com/u/r/commit/41608e5f41",
+			want:  "[#1](/u/r/issues/1) [#2](/u/r/pull/2) [`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) [`41608e5f41`](https://github.com/u/r/commit/41608e5f41)",
+		},
+		{
+			what:  "relative commits and absolute issues",
+			kinds: []RefKind{RefCommit},
+			input: "#1 41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[#1](https://github.com/u/r/issues/1) [`41608e5f41`](/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "no kind",
+			input: "#1 41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[#1](/u/r/issues/1) [`41608e5f41`](/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "other repository is still absolute",
+			kinds: []RefKind{RefIssue},
+			input: "foo/bar#1",
+			want:  "[foo/bar#1](https://github.com/foo/bar/issues/1)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetRelativeLinks(true)
+			l.SetRelativeKinds(tc.kinds...)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l := NewReflinker("https://github.com/u/r")
+	l.SetRelativeKinds(RefIssue)
+	want := "[#1](https://github.com/u/r/issues/1)"
+	if have := l.Link("#1"); have != want {
+		t.Fatalf("relative kinds should not enable relative links: wanted %q but got %q", want, have)
+	}
+}
+
+func TestReferencedIssues(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  []int
+	}{
+		{
+			what:  "issues",
+			input: "#12 #5 #1",
+			want:  []int{1, 5, 12},
+		},
+		{
+			what:  "duplicates",
+			input: "#5 #1 GH-5 https://github.com/u/r/pull/1 https://github.com/u/r/issues/5#issuecomment-1346614286",
+			want:  []int{1, 5},
+		},
+		{
+			what:  "cross-repository references",
+			input: "#3 foo/bar#1 https://github.com/foo/bar/issues/2 u/r#4 https://github.com/u/r2/issues/5",
+			want:  []int{3, 4},
+		},
+		{
+			what:  "other references",
+			input: "@foo 41608e5f4109208a6ab995c58266554e6071c5b2 `#1`",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			have := l.ReferencedIssues(tc.input)
+			if diff := cmp.Diff(tc.want, have); diff != "" {
+				t.Fatalf("issue numbers mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRefs(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	input := "https://github.com/u/r/issues/1 #2 GH-3 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 `#4`"
+	want := []Ref{
+		{RefIssueURL, "https://github.com/u/r/issues/1", "https://github.com/u/r/issues/1"},
+		{RefIssue, "#2", "https://github.com/u/r/issues/2"},
+		{RefExt, "GH-3", "https://github.com/u/r/issues/3"},
+		{RefUser, "@foo", "https://github.com/foo"},
+		{RefCommit, "41608e5f4109208a6ab995c58266554e6071c5b2", "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2"},
+	}
+	have := l.Refs(input)
+	if !cmp.Equal(have, want) {
+		t.Fatal(cmp.Diff(have, want))
+	}
+	if have := l.Refs("nothing"); have == nil || len(have) != 0 {
+		t.Fatalf("wanted empty slice but got %#v", have)
+	}
+}
+
+func TestLinkDiff(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	input := "fix #1 by @foo\n\n- see https://github.com/u/r/pull/2 and `#3`\n"
+	want := `@@ 1:5 issue @@
+-#1
++[#1](https://github.com/u/r/issues/1)
+@@ 1:11 user @@
+-@foo
++[@foo](https://github.com/foo)
+@@ 3:7 issue-url @@
+-https://github.com/u/r/pull/2
++[#2](https://github.com/u/r/pull/2)
+`
+	if have := l.LinkDiff(input); have != want {
+		t.Fatalf("diff mismatch (-want +got):\n%s", cmp.Diff(want, have))
+	}
+
+	if have := l.LinkDiff("nothing to link `#1`"); have != "" {
+		t.Fatalf("wanted empty diff but got %q", have)
+	}
+}
+
+func TestLinkJSON(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+
+	b, err := l.LinkJSON("GH-1 #2 @foo https://github.com/u/r/pull/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have []map[string]any
+	if err := json.Unmarshal(b, &have); err != nil {
+		t.Fatalf("output is not a JSON array: %v: %s", err, b)
+	}
+	want := []map[string]any{
+		{"kind": "ext", "text": "GH-1", "url": "https://github.com/u/r/issues/1", "start": 0.0, "end": 4.0},
+		{"kind": "issue", "text": "#2", "url": "https://github.com/u/r/issues/2", "start": 5.0, "end": 7.0},
+		{"kind": "user", "text": "@foo", "url": "https://github.com/foo", "start": 8.0, "end": 12.0},
+		{"kind": "issue-url", "text": "https://github.com/u/r/pull/3", "url": "https://github.com/u/r/pull/3", "start": 13.0, "end": 42.0},
+	}
+	if !cmp.Equal(have, want) {
+		t.Fatal(cmp.Diff(have, want))
+	}
+
+	b, err = l.LinkJSON("\xef\xbb\xbf#1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"kind":"issue","text":"#1","url":"https://github.com/u/r/issues/1","start":3,"end":5}]`; string(b) != want {
+		t.Fatalf("wanted %s but got %s", want, b)
+	}
+
+	b, err = l.LinkJSON("nothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "[]" {
+		t.Fatalf("wanted empty array but got %s", b)
+	}
+}
+
+func TestLinkAcceptUppercaseSHA(t *testing.T) {
+	tests := []struct {
+		what    string
+		input   string
+		want    string
+		enabled string
+	}{
+		{
+			what:    "lowercase",
+			input:   "41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:    "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+			enabled: "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:    "uppercase",
+			input:   "41608E5F4109208A6AB995C58266554E6071C5B2",
+			want:    "41608E5F4109208A6AB995C58266554E6071C5B2",
+			enabled: "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:    "mixed case",
+			input:   "41608e5f4109208a6ab995c58266554E6071C5B2",
+			want:    "41608e5f4109208a6ab995c58266554E6071C5B2",
+			enabled: "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:    "uppercase with repository slug",
+			input:   "foo/bar@41608E5",
+			want:    "foo/bar@41608E5",
+			enabled: "[foo/bar@`41608e5`](https://github.com/foo/bar/commit/41608e5)",
+		},
+		{
+			what:    "short uppercase hex",
+			input:   "DEADBEEF",
+			want:    "DEADBEEF",
+			enabled: "DEADBEEF",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			if have := l.Link(tc.input); have != tc.want {
+				t.Fatalf("wanted %q by default but got %q", tc.want, have)
+			}
+			l.SetAcceptUppercaseSHA(true)
+			if have := l.Link(tc.input); have != tc.enabled {
+				t.Fatalf("wanted %q when enabled but got %q", tc.enabled, have)
+			}
+		})
+	}
+}
+
+func TestLinkIndentedCodeBlocks(t *testing.T) {
+	tests := []struct {
+		what     string
+		input    string
+		want     string
+		disabled string
+	}{
+		{
+			what:     "indented text at start",
+			input:    "    #123 by @foo",
+			want:     "    #123 by @foo",
+			disabled: "    [#123](https://github.com/u/r/issues/123) by [@foo](https://github.com/foo)",
+		},
+		{
+			what:     "indented paragraph after paragraph",
+			input:    "#1\n\n    #2\n    #3",
+			want:     "[#1](https://github.com/u/r/issues/1)\n\n    #2\n    #3",
+			disabled: "[#1](https://github.com/u/r/issues/1)\n\n    [#2](https://github.com/u/r/issues/2)\n    [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:     "indented text with tab",
+			input:    "\t#1",
+			want:     "\t#1",
+			disabled: "\t[#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:     "inline markups in indented text",
+			input:    "    `#1` [#2](https://example.com) https://github.com/u/r/pull/3",
+			want:     "    `#1` [#2](https://example.com) https://github.com/u/r/pull/3",
+			disabled: "    `#1` [#2](https://example.com) [#3](https://github.com/u/r/pull/3)",
+		},
+		{
+			what:     "indented list item content",
+			input:    "- foo\n\n      #1",
+			want:     "- foo\n\n      #1",
+			disabled: "- foo\n\n      [#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:     "fenced code block",
+			input:    "```\n#1\n```",
+			want:     "```\n#1\n```",
+			disabled: "```\n#1\n```",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			if have := l.Link(tc.input); have != tc.want {
+				t.Fatalf("wanted %q by default but got %q", tc.want, have)
+			}
+			l.SetIndentedCodeBlocks(false)
+			if have := l.Link(tc.input); have != tc.disabled {
+				t.Fatalf("wanted %q when disabled but got %q", tc.disabled, have)
+			}
+		})
+	}
+}
+
+func TestLinkNormalizeFullWidth(t *testing.T) {
+	tests := []struct {
+		what      string
+		input     string
+		normalize bool
+		want      string
+	}{
+		{
+			what:  "issue is not linked by default",
+			input: "fix ＃123",
+			want:  "fix ＃123",
+		},
+		{
+			what:  "user is not linked by default",
+			input: "thanks ＠foo",
+			want:  "thanks ＠foo",
+		},
+		{
+			what:      "issue",
+			input:     "fix ＃123",
+			normalize: true,
+			want:      "fix [#123](https://github.com/u/r/issues/123)",
+		},
+		{
+			what:      "user",
+			input:     "thanks ＠foo",
+			normalize: true,
+			want:      "thanks [@foo](https://github.com/foo)",
+		},
+		{
+			what:      "cross-repository issue",
+			input:     "fix foo/bar＃1",
+			normalize: true,
+			want:      "fix [foo/bar#1](https://github.com/foo/bar/issues/1)",
+		},
+		{
+			what:      "no reference",
+			input:     "＃ and ＠",
+			normalize: true,
+			want:      "＃ and ＠",
+		},
+		{
+			what:      "only linked sigils are replaced",
+			input:     "＃1 ＃ `＃2`\n\n```\n＠foo\n```",
+			normalize: true,
+			want:      "[#1](https://github.com/u/r/issues/1) ＃ `＃2`\n\n```\n＠foo\n```",
+		},
+		{
+			what:      "after non-boundary",
+			input:     "a＃1 b＠foo",
+			normalize: true,
+			want:      "a＃1 b＠foo",
+		},
+		{
+			what:      "escaped",
+			input:     "\\＃1",
+			normalize: true,
+			want:      "\\＃1",
+		},
+		{
+			what:      "cross-repository commit and mixed sigils",
+			input:     "foo/bar＠41608e5 ＠foo＃1 [a]＃2",
+			normalize: true,
+			want:      "[foo/bar@`41608e5`](https://github.com/foo/bar/commit/41608e5) [@foo](https://github.com/foo)＃1 [a][#2](https://github.com/u/r/issues/2)",
+		},
+		{
+			what:      "ASCII",
+			input:     "#1 @foo",
+			normalize: true,
+			want:      "[#1](https://github.com/u/r/issues/1) [@foo](https://github.com/foo)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l, err := NewReflinkerConfig(&ReflinkerConfig{
+				RepoURL:            "https://github.com/u/r",
+				NormalizeFullWidth: tc.normalize,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+			if have, _ := l.LinkWithReport(tc.input); have != tc.want {
+				t.Fatalf("wanted %q but got %q with LinkWithReport", tc.want, have)
+			}
+			if have := string(l.AppendLink(nil, []byte(tc.input))); have != tc.want {
+				t.Fatalf("wanted %q but got %q with AppendLink", tc.want, have)
+			}
+			var b strings.Builder
+			if err := l.LinkTo(&b, []byte(tc.input)); err != nil {
+				t.Fatal(err)
+			}
+			if have := b.String(); have != tc.want {
+				t.Fatalf("wanted %q but got %q with LinkTo", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkIssueURLTemplate(t *testing.T) {
+	tests := []struct {
+		what  string
+		tmpl  string
+		input string
+		want  string
+	}{
+		{
+			what:  "issue reference",
+			tmpl:  "https://tracker.example.com/r/<num>",
+			input: "fix #123",
+			want:  "fix [#123](https://tracker.example.com/r/123)",
+		},
+		{
+			what:  "repository placeholder",
+			tmpl:  "https://tracker.example.com/?repo=<repo>&id=<num>",
+			input: "fix #123",
+			want:  "fix [#123](https://tracker.example.com/?repo=https://github.com/u/r&id=123)",
+		},
+		{
+			what:  "cross-repository reference",
+			tmpl:  "https://tracker.example.com/r/<num>",
+			input: "#1 foo/bar#2 https://github.com/u/r/issues/3 GH-4",
+			want:  "[#1](https://tracker.example.com/r/1) [foo/bar#2](https://github.com/foo/bar/issues/2) [#3](https://github.com/u/r/issues/3) [GH-4](https://github.com/u/r/issues/4)",
+		},
+		{
+			what:  "default",
+			input: "fix #123",
+			want:  "fix [#123](https://github.com/u/r/issues/123)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetIssueURLTemplate(tc.tmpl)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkReferenceCommentFormat(t *testing.T) {
+	input := "https://github.com/u/r/issues/1#issuecomment-1346614286 https://github.com/foo/bar/pull/2#pullrequestreview-1212591132 https://github.com/u/r/issues/3"
+	tests := []struct {
+		what    string
+		comment string
+		review  string
+		want    string
+	}{
+		{
+			what:    "custom suffixes",
+			comment: " (コメント)",
+			review:  " 👀",
+			want:    "[#1 (コメント)](https://github.com/u/r/issues/1#issuecomment-1346614286) [foo/bar#2 👀](https://github.com/foo/bar/pull/2#pullrequestreview-1212591132) [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what: "no suffix",
+			want: "[#1](https://github.com/u/r/issues/1#issuecomment-1346614286) [foo/bar#2](https://github.com/foo/bar/pull/2#pullrequestreview-1212591132) [#3](https://github.com/u/r/issues/3)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetReferenceCommentFormat(tc.comment, tc.review)
+			have := l.Link(input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	empty := ""
+	l, err := NewReflinkerConfig(&ReflinkerConfig{RepoURL: "https://github.com/u/r", CommentSuffix: &empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[#1](https://github.com/u/r/issues/1#issuecomment-1346614286) [foo/bar#2 (review)](https://github.com/foo/bar/pull/2#pullrequestreview-1212591132) [#3](https://github.com/u/r/issues/3)"
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkShowFullSHA(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "commit sha",
+			input: "41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[`41608e5f4109208a6ab995c58266554e6071c5b2`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "commit sha with repository slug",
+			input: "foo/bar@41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[foo/bar@`41608e5f4109208a6ab995c58266554e6071c5b2`](https://github.com/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "commit URL",
+			input: "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[`41608e5f4109208a6ab995c58266554e6071c5b2`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "commit URL outside the repository",
+			input: "https://github.com/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[foo/bar@`41608e5f4109208a6ab995c58266554e6071c5b2`](https://github.com/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "commit URL with partial hash",
+			input: "https://github.com/u/r/commit/41608e5f4109",
+			want:  "[`41608e5f4109`](https://github.com/u/r/commit/41608e5f4109)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetShowFullSHA(true)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkCollapseIssueRanges(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "consecutive issues",
+			input: "fix #10 #11 #12 #13",
+			want:  "fix [#10–#13](https://github.com/u/r/issues/10)",
+		},
+		{
+			what:  "separated by commas",
+			input: "fix #10, #11, #12.",
+			want:  "fix [#10–#12](https://github.com/u/r/issues/10).",
+		},
+		{
+			what:  "non-consecutive issues",
+			input: "fix #10 #12 #14",
+			want:  "fix [#10](https://github.com/u/r/issues/10) [#12](https://github.com/u/r/issues/12) [#14](https://github.com/u/r/issues/14)",
+		},
+		{
+			what:  "two consecutive issues",
+			input: "fix #10 #11",
+			want:  "fix [#10](https://github.com/u/r/issues/10) [#11](https://github.com/u/r/issues/11)",
+		},
+		{
+			what:  "multiple ranges",
+			input: "#1 #2 #3 #5 #6 #7 #8 #10",
+			want:  "[#1–#3](https://github.com/u/r/issues/1) [#5–#8](https://github.com/u/r/issues/5) [#10](https://github.com/u/r/issues/10)",
+		},
+		{
+			what:  "separated by words",
+			input: "#1 and #2 and #3",
+			want:  "[#1](https://github.com/u/r/issues/1) and [#2](https://github.com/u/r/issues/2) and [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "cross-repository references are not collapsed",
+			input: "#1 foo/bar#2 #3 #4",
+			want:  "[#1](https://github.com/u/r/issues/1) [foo/bar#2](https://github.com/foo/bar/issues/2) [#3](https://github.com/u/r/issues/3) [#4](https://github.com/u/r/issues/4)",
+		},
+		{
+			what:  "same-repository references with slug are not collapsed",
+			input: "#12 u/r#13 #14 #15 #16",
+			want:  "[#12](https://github.com/u/r/issues/12) [#13](https://github.com/u/r/issues/13) [#14–#16](https://github.com/u/r/issues/14)",
+		},
+		{
+			what:  "issue URLs are not collapsed",
+			input: "#1 https://github.com/u/r/issues/2 #3",
+			want:  "[#1](https://github.com/u/r/issues/1) [#2](https://github.com/u/r/issues/2) [#3](https://github.com/u/r/issues/3)",
+		},
+		{
+			what:  "in different lines",
+			input: "- #1\n- #2\n- #3",
+			want:  "- [#1](https://github.com/u/r/issues/1)\n- [#2](https://github.com/u/r/issues/2)\n- [#3](https://github.com/u/r/issues/3)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetCollapseIssueRanges(true)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l := NewReflinker("https://github.com/u/r")
+	if have := l.Link("#1 #2 #3"); strings.Contains(have, "–") {
+		t.Fatalf("issues were collapsed by default: %q", have)
+	}
+	l.SetCollapseIssueRanges(true)
+	want := []int{1, 2, 3}
+	if have := l.ReferencedIssues("#1 #2 #3"); !cmp.Equal(have, want) {
+		t.Fatalf("wanted %v but got %v", want, have)
+	}
+}
+
+func TestLinkMentionTriggers(t *testing.T) {
+	tests := []struct {
+		what     string
+		input    string
+		triggers []string
+		want     string
+	}{
+		{
+			what:     "thanks to",
+			input:    "Thanks to @foo and @bar",
+			triggers: []string{"thanks to", "by", "cc"},
+			want:     "Thanks to [@foo](https://github.com/foo) and [@bar](https://github.com/bar)",
+		},
+		{
+			what:     "stray mention",
+			input:    "Use @Override annotation",
+			triggers: []string{"thanks to", "by", "cc"},
+			want:     "Use @Override annotation",
+		},
+		{
+			what:     "trigger after mention",
+			input:    "@foo by @bar",
+			triggers: []string{"by"},
+			want:     "@foo by [@bar](https://github.com/bar)",
+		},
+		{
+			what:     "trigger in other line",
+			input:    "Reported by someone\n@foo\ncc: @bar",
+			triggers: []string{"by", "cc"},
+			want:     "Reported by someone\n@foo\ncc: [@bar](https://github.com/bar)",
+		},
+		{
+			what:     "trigger as part of word",
+			input:    "bypass @foo",
+			triggers: []string{"by"},
+			want:     "bypass @foo",
+		},
+		{
+			what:     "trigger in emphasis",
+			input:    "Fixed *by* @foo in #1",
+			triggers: []string{"by"},
+			want:     "Fixed *by* [@foo](https://github.com/foo) in [#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:  "no trigger",
+			input: "Use @Override annotation",
+			want:  "Use [@Override](https://github.com/Override) annotation",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetMentionTriggers(tc.triggers)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkUserURLTemplate(t *testing.T) {
+	tests := []struct {
+		what string
+		tmpl string
+		want string
+	}{
+		{
+			what: "mailto",
+			tmpl: "mailto:<user>@corp.com",
+			want: "thanks [@foo](mailto:foo@corp.com) and [@bar-baz](mailto:bar-baz@corp.com)",
+		},
+		{
+			what: "custom scheme",
+			tmpl: "slack://user?team=T123&id=<user>",
+			want: "thanks [@foo](slack://user?team=T123&id=foo) and [@bar-baz](slack://user?team=T123&id=bar-baz)",
+		},
+		{
+			what: "home placeholder",
+			tmpl: "<home>/orgs/acme/people/<user>",
+			want: "thanks [@foo](https://github.com/orgs/acme/people/foo) and [@bar-baz](https://github.com/orgs/acme/people/bar-baz)",
+		},
+		{
+			what: "default",
+			want: "thanks [@foo](https://github.com/foo) and [@bar-baz](https://github.com/bar-baz)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l, err := NewReflinkerConfig(&ReflinkerConfig{
+				RepoURL:         "https://github.com/u/r",
+				UserURLTemplate: tc.tmpl,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := l.Link("thanks @foo and @bar-baz")
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+
+			// Check the generated links are valid markdown links
+			var dests []string
+			ast.Walk(parseMarkdown([]byte(have)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+				if l, ok := n.(*ast.Link); ok && entering {
+					dests = append(dests, string(l.Destination))
+				}
+				return ast.WalkContinue, nil
+			})
+			if len(dests) != 2 {
+				t.Fatalf("wanted 2 markdown links but got %q", dests)
+			}
+		})
+	}
+}
+
+func TestLinkIssuePRResolver(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	l.SetIssuePRResolver(func(num int) IssueKind {
+		if num%2 == 0 {
+			return IssueKindPullRequest
+		}
+		return IssueKindIssue
+	})
+
+	input := "#1 #2 foo/bar#4 u/r#4 u/r#5 GH-6 GH-7 https://github.com/u/r/issues/6"
+	want := "[#1](https://github.com/u/r/issues/1) [PR #2](https://github.com/u/r/pull/2) [foo/bar#4](https://github.com/foo/bar/issues/4) " +
+		"[PR #4](https://github.com/u/r/pull/4) [#5](https://github.com/u/r/issues/5) " +
+		"[PR GH-6](https://github.com/u/r/pull/6) [GH-7](https://github.com/u/r/issues/7) [#6](https://github.com/u/r/issues/6)"
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+
+	l.SetIssuePRResolver(nil)
+	want = "[#1](https://github.com/u/r/issues/1) [#2](https://github.com/u/r/issues/2) [foo/bar#4](https://github.com/foo/bar/issues/4) " +
+		"[#4](https://github.com/u/r/issues/4) [#5](https://github.com/u/r/issues/5) " +
+		"[GH-6](https://github.com/u/r/issues/6) [GH-7](https://github.com/u/r/issues/7) [#6](https://github.com/u/r/issues/6)"
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkMaxInputSize(t *testing.T) {
+	input := "fix #1" // 6 bytes
+	want := "fix [#1](https://github.com/u/r/issues/1)"
+
+	l := NewReflinker("https://github.com/u/r")
+	l.SetMaxInputSize(len(input))
+	if have := l.Link(input); have != want {
+		t.Errorf("wanted %q but got %q at the boundary", want, have)
+	}
+
+	l.SetMaxInputSize(len(input) - 1)
+	if have := l.Link(input); have != input {
+		t.Errorf("wanted %q but got %q for too large input", input, have)
+	}
+	if refs := l.Refs(input); len(refs) != 0 {
+		t.Errorf("wanted no reference for too large input but got %v", refs)
+	}
+
+	l.SetMaxInputSize(0)
+	if have := l.Link(input); have != want {
+		t.Errorf("wanted %q but got %q without limit", want, have)
+	}
+
+	l, err := NewReflinkerConfig(&ReflinkerConfig{RepoURL: "https://github.com/u/r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.maxInput != DefaultMaxInputSize {
+		t.Errorf("wanted default max input size %d but got %d", DefaultMaxInputSize, l.maxInput)
+	}
+}
+
+func TestLinkMaxUsernameLength(t *testing.T) {
+	name39 := strings.Repeat("a", 39)
+	name40 := strings.Repeat("a", 40)
+	tests := []struct {
+		what  string
+		max   int
+		input string
+		want  string
+	}{
+		{
+			what:  "39 characters by default",
+			input: "@" + name39,
+			want:  "[@" + name39 + "](https://github.com/" + name39 + ")",
+		},
+		{
+			what:  "40 characters by default",
+			input: "@" + name40 + " @foo",
+			want:  "@" + name40 + " [@foo](https://github.com/foo)",
+		},
+		{
+			what:  "custom limit",
+			max:   3,
+			input: "@abcd @abc",
+			want:  "@abcd [@abc](https://github.com/abc)",
+		},
+		{
+			what:  "no limit",
+			max:   -1,
+			input: "@" + name40,
+			want:  "[@" + name40 + "](https://github.com/" + name40 + ")",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l, err := NewReflinkerConfig(&ReflinkerConfig{
+				RepoURL:           "https://github.com/u/r",
+				MaxUsernameLength: tc.max,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l := NewReflinker("https://gitlab.com/u/r")
+	l.SetFlavor(FlavorGitLab)
+	want := "[@" + name40 + "](https://gitlab.com/" + name40 + ")"
+	if have := l.Link("@" + name40); have != want {
+		t.Fatalf("max user name length should be ignored on GitLab: wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkDebugLogger(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  []string
+	}{
+		{
+			what:  "issue reference after non-boundary",
+			input: "v#123",
+			want:  []string{"skipped #123: preceded by non-boundary 'v'"},
+		},
+		{
+			what:  "issue reference followed by non-boundary",
+			input: "#123abc",
+			want:  []string{"skipped #123abc: followed by non-boundary 'a'"},
+		},
+		{
+			what:  "user reference after non-boundary",
+			input: "foo@bar-baz",
+			want:  []string{"skipped @bar-baz: preceded by non-boundary 'o'"},
+		},
+		{
+			what:  "escaped reference",
+			input: "\\#1",
+			want:  []string{"skipped #1: escaped with backslash"},
+		},
+		{
+			what:  "linked reference",
+			input: "#1 @foo",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			var logs []string
+			l := NewReflinker("https://github.com/u/r")
+			l.SetDebugLogger(func(format string, args ...any) {
+				logs = append(logs, fmt.Sprintf(format, args...))
+			})
+			l.Link(tc.input)
+			if !cmp.Equal(logs, tc.want) {
+				t.Fatal(cmp.Diff(tc.want, logs))
+			}
+		})
+	}
+
+	l := NewReflinker("https://github.com/u/r")
+	l.SetIssueDenylist([]int{1})
+	var logs []string
+	l.SetDebugLogger(func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	})
+	l.Link("#1")
+	want := []string{"skipped #1: issue number is in denylist"}
+	if !cmp.Equal(logs, want) {
+		t.Fatal(cmp.Diff(want, logs))
+	}
+}
+
+func TestLinkRepoURLCase(t *testing.T) {
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "issue URL",
+			input: "https://github.com/owner/repo/issues/1",
+			want:  "[#1](https://github.com/owner/repo/issues/1)",
+		},
+		{
+			what:  "pull request URL with the same casing",
+			input: "https://github.com/Owner/Repo/pull/1",
+			want:  "[#1](https://github.com/Owner/Repo/pull/1)",
+		},
+		{
+			what:  "commit URL",
+			input: "https://github.com/OWNER/repo/commit/41608e5f41",
+			want:  "[`41608e5f41`](https://github.com/OWNER/repo/commit/41608e5f41)",
+		},
+		{
+			what:  "release URL",
+			input: "https://github.com/owner/REPO/releases/tag/v1.0.0",
+			want:  "[v1.0.0](https://github.com/owner/REPO/releases/tag/v1.0.0)",
+		},
+		{
+			what:  "issue reference with slug",
+			input: "owner/repo#1",
+			want:  "[#1](https://github.com/owner/repo/issues/1)",
+		},
+		{
+			what:  "commit reference with slug of other repository",
+			input: "owner/other@41608e5",
+			want:  "[other@`41608e5`](https://github.com/owner/other/commit/41608e5)",
+		},
+		{
+			what:  "URL of other repository",
+			input: "https://github.com/owner/repo2/issues/1",
+			want:  "[owner/repo2#1](https://github.com/owner/repo2/issues/1)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/Owner/Repo")
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l := NewReflinker("https://github.com/Owner/Repo")
+	l.SetRelativeLinks(true)
+	want := "[#1](/owner/repo/issues/1)"
+	if have := l.Link("https://github.com/owner/repo/issues/1"); have != want {
+		t.Fatalf("wanted %q but got %q with relative links", want, have)
+	}
+	if have := l.ReferencedIssues("owner/repo#1 https://github.com/OWNER/REPO/pull/2"); !cmp.Equal(have, []int{1, 2}) {
+		t.Fatalf("wanted [1 2] but got %v", have)
+	}
+}
+
+func TestLinkFormat(t *testing.T) {
+	input := "fix #1 in 41608e5f4109208a6ab995c58266554e6071c5b2 by @foo"
+	tests := []struct {
+		what   string
+		format LinkFormat
+		want   string
+	}{
+		{
+			what:   "markdown",
+			format: LinkFormatMarkdown,
+			want:   "fix [#1](https://github.com/u/r/issues/1) in [`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) by [@foo](https://github.com/foo)",
+		},
+		{
+			what:   "org",
+			format: LinkFormatOrg,
+			want:   "fix [[https://github.com/u/r/issues/1][#1]] in [[https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2][~41608e5f41~]] by [[https://github.com/foo][@foo]]",
+		},
+		{
+			what:   "rst",
+			format: LinkFormatRST,
+			want:   "fix `#1 <https://github.com/u/r/issues/1>`_ in `41608e5f41 <https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2>`_ by `@foo <https://github.com/foo>`_",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetLinkFormat(tc.format)
+			if have := l.Link(input); have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+			if have := string(l.AppendLink(nil, []byte(input))); have != tc.want {
+				t.Fatalf("wanted %q but got %q with AppendLink", tc.want, have)
+			}
+		})
+	}
+
+	l := NewReflinker("https://github.com/u/r")
+	l.SetLinkFormat(LinkFormatOrg)
+	want := "[foo][[https://github.com/u/r/issues/1][#1]]\n\n[foo]: https://example.com"
+	if have := l.Link("[foo]#1\n\n[foo]: https://example.com"); have != want {
+		t.Fatalf("wanted %q but got %q after shortcut reference link", want, have)
+	}
+}
+
+func TestLinkLeadingZeros(t *testing.T) {
+	input := "#007 #0 #10 foo/bar#01"
+	tests := []struct {
+		what string
+		z    LeadingZeros
+		want string
+	}{
+		{
+			what: "keep",
+			z:    LeadingZerosKeep,
+			want: "[#007](https://github.com/u/r/issues/007) [#0](https://github.com/u/r/issues/0) [#10](https://github.com/u/r/issues/10) [foo/bar#01](https://github.com/foo/bar/issues/01)",
+		},
+		{
+			what: "strip",
+			z:    LeadingZerosStrip,
+			want: "[#007](https://github.com/u/r/issues/7) [#0](https://github.com/u/r/issues/0) [#10](https://github.com/u/r/issues/10) [foo/bar#01](https://github.com/foo/bar/issues/1)",
+		},
+		{
+			what: "reject",
+			z:    LeadingZerosReject,
+			want: "#007 #0 [#10](https://github.com/u/r/issues/10) foo/bar#01",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetLeadingZeros(tc.z)
+			have := l.Link(input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l, err := NewReflinkerConfig(&ReflinkerConfig{
+		RepoURL:      "https://gitlab.com/u/r",
+		Flavor:       FlavorGitLab,
+		LeadingZeros: LeadingZerosStrip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[!007](https://gitlab.com/u/r/-/merge_requests/7)"
+	if have := l.Link("!007"); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkLeadingZerosRejectLikeGitHub(t *testing.T) {
+	tests := []struct {
+		what   string
+		input  string
+		github string // Output rendered by GitHub
+		loose  string // Output with LeadingZerosKeep
+	}{
+		{
+			what:   "issue",
+			input:  "#1",
+			github: "[#1](https://github.com/u/r/issues/1)",
+			loose:  "[#1](https://github.com/u/r/issues/1)",
+		},
+		{
+			what:   "issue number zero",
+			input:  "#0",
+			github: "#0",
+			loose:  "[#0](https://github.com/u/r/issues/0)",
+		},
+		{
+			what:   "leading zeros",
+			input:  "#007",
+			github: "#007",
+			loose:  "[#007](https://github.com/u/r/issues/007)",
+		},
+		{
+			what:   "number includes zero",
+			input:  "#100",
+			github: "[#100](https://github.com/u/r/issues/100)",
+			loose:  "[#100](https://github.com/u/r/issues/100)",
+		},
+		{
+			what:   "cross-repository issue with leading zero",
+			input:  "foo/bar#01",
+			github: "foo/bar#01",
+			loose:  "[foo/bar#01](https://github.com/foo/bar/issues/01)",
+		},
+		{
+			what:   "user",
+			input:  "@foo",
+			github: "[@foo](https://github.com/foo)",
+			loose:  "[@foo](https://github.com/foo)",
+		},
+		{
+			what:   "issue in underscore emphasis",
+			input:  "_#123_",
+			github: "_[#123](https://github.com/u/r/issues/123)_",
+			loose:  "_[#123](https://github.com/u/r/issues/123)_",
+		},
+		{
+			what:   "issue in double underscores emphasis",
+			input:  "__#123__",
+			github: "__[#123](https://github.com/u/r/issues/123)__",
+			loose:  "__[#123](https://github.com/u/r/issues/123)__",
+		},
+		{
+			what:   "issue followed by underscore",
+			input:  "#123_",
+			github: "#123_",
+			loose:  "#123_",
+		},
+		{
+			what:   "issue preceded by underscore",
+			input:  "foo_#123",
+			github: "foo_#123",
+			loose:  "foo_#123",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			if have := l.Link(tc.input); have != tc.loose {
+				t.Errorf("wanted %q but got %q in default mode", tc.loose, have)
+			}
+			l.SetLeadingZeros(LeadingZerosReject)
+			if have := l.Link(tc.input); have != tc.github {
+				t.Errorf("wanted %q but got %q with LeadingZerosReject", tc.github, have)
+			}
+		})
+	}
+}
+
+func TestLinkCompareLabel(t *testing.T) {
+	input := "https://github.com/u/r/compare/v1.0.0...v2.0.0 https://github.com/foo/bar/compare/v1.0.0..v2.0.0"
+	tests := []struct {
+		what  string
+		label string
+		want  string
+	}{
+		{
+			what: "range",
+			want: "[v1.0.0...v2.0.0](https://github.com/u/r/compare/v1.0.0...v2.0.0) [foo/bar@v1.0.0..v2.0.0](https://github.com/foo/bar/compare/v1.0.0..v2.0.0)",
+		},
+		{
+			what:  "fixed label",
+			label: "Full Changelog",
+			want:  "[Full Changelog](https://github.com/u/r/compare/v1.0.0...v2.0.0) [Full Changelog](https://github.com/foo/bar/compare/v1.0.0..v2.0.0)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetCompareLabel(tc.label)
+			have := l.Link(input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkCommitSHALength(t *testing.T) {
+	tests := []struct {
+		what   string
+		length int
+		input  string
+		want   string
+	}{
+		{
+			what:  "7 characters URL hash with default length",
+			input: "https://github.com/u/r/commit/41608e5",
+			want:  "[`41608e5`](https://github.com/u/r/commit/41608e5)",
+		},
+		{
+			what:  "10 characters URL hash with default length",
+			input: "https://github.com/u/r/commit/41608e5f41",
+			want:  "[`41608e5f41`](https://github.com/u/r/commit/41608e5f41)",
+		},
+		{
+			what:  "40 characters URL hash with default length",
+			input: "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[`41608e5f41`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:   "7 characters URL hash",
+			length: 8,
+			input:  "https://github.com/u/r/commit/41608e5",
+			want:   "[`41608e5`](https://github.com/u/r/commit/41608e5)",
+		},
+		{
+			what:   "10 characters URL hash",
+			length: 8,
+			input:  "https://github.com/u/r/commit/41608e5f41",
+			want:   "[`41608e5f`](https://github.com/u/r/commit/41608e5f41)",
+		},
+		{
+			what:   "40 characters URL hash",
+			length: 8,
+			input:  "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:   "[`41608e5f`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:   "longer than default",
+			length: 12,
+			input:  "https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:   "[`41608e5f4109`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:   "commit sha",
+			length: 7,
+			input:  "41608e5f4109208a6ab995c58266554e6071c5b2 foo/bar@41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:   "[`41608e5`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) [foo/bar@`41608e5`](https://github.com/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetCommitSHALength(tc.length)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	want := Capabilities{Issues: true, Users: true, Commits: true, ExtRefs: true, URLs: true}
+	if have := l.Capabilities(); have != want {
+		t.Errorf("wanted %+v but got %+v", want, have)
+	}
+	kinds := []RefKind{
+		RefIssue,
+		RefUser,
+		RefCommit,
+		RefExt,
+		RefIssueURL,
+		RefCommitURL,
+		RefReleaseURL,
+		RefCompareURL,
+		RefBlobURL,
+		RefAdvisoryURL,
+	}
+	if diff := cmp.Diff(kinds, l.SupportedRefKinds()); diff != "" {
+		t.Errorf("supported reference kinds mismatch (-want +got):\n%s", diff)
+	}
+
+	l, err := NewReflinkerConfig(&ReflinkerConfig{
+		RepoURL:          "https://gitlab.com/u/r",
+		Flavor:           FlavorGitLab,
+		LinkVersionTags:  true,
+		LinkForeignHosts: true,
+		RegexpRefs:       []RegexpRefConfig{{Pattern: regexp.MustCompile(`CVE-\d+-\d+`), URL: "https://nvd.nist.gov/vuln/detail/$0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Capabilities{
+		Issues:       true,
+		Users:        true,
+		Commits:      true,
+		ExtRefs:      true,
+		RegexpRefs:   true,
+		URLs:         true,
+		GitLabRefs:   true,
+		Versions:     true,
+		ForeignHosts: true,
+	}
+	if have := l.Capabilities(); have != want {
+		t.Errorf("wanted %+v but got %+v", want, have)
+	}
+	kinds = []RefKind{
+		RefIssue,
+		RefUser,
+		RefCommit,
+		RefExt,
+		RefIssueURL,
+		RefCommitURL,
+		RefMergeRequest,
+		RefMilestone,
+		RefVersion,
+		RefReleaseURL,
+		RefCompareURL,
+		RefBlobURL,
+		RefAdvisoryURL,
+		RefRegexp,
+	}
+	if diff := cmp.Diff(kinds, l.SupportedRefKinds()); diff != "" {
+		t.Errorf("supported reference kinds mismatch (-want +got):\n%s", diff)
+	}
+
+	l.SetEnabled(false)
+	if have := l.Capabilities(); have != (Capabilities{}) {
+		t.Errorf("wanted no capability but got %+v", have)
+	}
+	if have := l.SupportedRefKinds(); len(have) != 0 {
+		t.Errorf("wanted no reference kind but got %v", have)
+	}
+}
+
+func TestCapabilitiesUsers(t *testing.T) {
+	tests := []struct {
+		what     string
+		allow    []string
+		triggers []string
+		users    bool
+		limited  bool
+	}{
+		{what: "default", users: true},
+		{what: "allowlist", allow: []string{"foo"}, users: true, limited: true},
+		{what: "empty allowlist", allow: []string{}},
+		{what: "mention triggers", triggers: []string{"thanks to"}, users: true, limited: true},
+		{what: "empty mention triggers", triggers: []string{""}, users: true},
+		{what: "empty allowlist and mention triggers", allow: []string{}, triggers: []string{"by"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			l.SetUserAllowlist(tc.allow)
+			l.SetMentionTriggers(tc.triggers)
+			c := l.Capabilities()
+			if c.Users != tc.users || c.LimitedUsers != tc.limited {
+				t.Errorf("wanted Users=%v and LimitedUsers=%v but got %+v", tc.users, tc.limited, c)
+			}
+			if have := slices.Contains(l.SupportedRefKinds(), RefUser); have != tc.users {
+				t.Errorf("wanted %v for user references in supported kinds but got %v", tc.users, have)
+			}
+		})
+	}
+}
+
+func TestLinkDisabled(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	l.SetEnabled(false)
+	input := "#1 @foo GH-2 https://github.com/u/r/issues/3 41608e5f4109208a6ab995c58266554e6071c5b2"
+
+	if have := l.Link(input); have != input {
+		t.Errorf("wanted %q but got %q", input, have)
+	}
+	if n := testing.AllocsPerRun(10, func() { l.Link(input) }); n != 0 {
+		t.Errorf("wanted no allocation but got %v allocations", n)
+	}
+	have, s := l.LinkWithReport(input)
+	if have != input {
+		t.Errorf("wanted %q but got %q", input, have)
+	}
+	if s != (LinkStats{}) {
+		t.Errorf("wanted no link but got %+v", s)
+	}
+	var b bytes.Buffer
+	if err := l.LinkTo(&b, []byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	if have := b.String(); have != input {
+		t.Errorf("wanted %q but got %q", input, have)
+	}
+
+	l.SetEnabled(true)
+	want := "[#1](https://github.com/u/r/issues/1)"
+	if have := l.Link("#1"); have != want {
+		t.Errorf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkWithReport(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	l.AddExtRef("FOO-", "https://example.com/foo/<num>", false)
+
+	input := "#1 #2 @foo 41608e5f4109208a6ab995c58266554e6071c5b2 foo/bar@41608e5 GH-3 FOO-4 https://github.com/u/r/issues/5 https://github.com/u/r/commit/41608e5f41 `#6`"
+	have, stats := l.LinkWithReport(input)
+	if want := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+	want := LinkStats{
+		Issues:  2,
+		Users:   1,
+		Commits: 2,
+		URLs:    2,
+		ExtRefs: 2,
+	}
+	if !cmp.Equal(stats, want) {
+		t.Fatal(cmp.Diff(stats, want))
+	}
+
+	// Counters are reset per call
+	have, stats = l.LinkWithReport("nothing to link")
+	if have != "nothing to link" {
+		t.Fatalf("unexpected output %q", have)
+	}
+	if !cmp.Equal(stats, LinkStats{}) {
+		t.Fatal(cmp.Diff(stats, LinkStats{}))
+	}
+}
+
+func TestLinkFlavorTriggers(t *testing.T) {
+	tests := []struct {
+		what   string
+		flavor Flavor
+		input  string
+		want   string
+	}{
+		{
+			what:   "GitHub does not link merge requests and milestones",
+			flavor: FlavorGitHub,
+			input:  "!1 %2 #3",
+			want:   "!1 %2 [#3](https://gitlab.com/u/r/issues/3)",
+		},
+		{
+			what:   "GitLab issue",
+			flavor: FlavorGitLab,
+			input:  "#1",
+			want:   "[#1](https://gitlab.com/u/r/-/issues/1)",
+		},
+		{
+			what:   "GitLab merge request",
+			flavor: FlavorGitLab,
+			input:  "fixed by !12.",
+			want:   "fixed by [!12](https://gitlab.com/u/r/-/merge_requests/12).",
+		},
+		{
+			what:   "GitLab milestone",
+			flavor: FlavorGitLab,
+			input:  "(%3)",
+			want:   "([%3](https://gitlab.com/u/r/-/milestones/3))",
+		},
+		{
+			what:   "GitLab user",
+			flavor: FlavorGitLab,
+			input:  "@foo",
+			want:   "[@foo](https://gitlab.com/foo)",
+		},
+		{
+			what:   "GitLab user with dot and underscore",
+			flavor: FlavorGitLab,
+			input:  "thanks @foo.bar_baz.",
+			want:   "thanks [@foo.bar_baz](https://gitlab.com/foo.bar_baz).",
+		},
+		{
+			what:   "GitLab user starting with underscore",
+			flavor: FlavorGitLab,
+			input:  "@_foo",
+			want:   "[@_foo](https://gitlab.com/_foo)",
+		},
+		{
+			what:   "GitLab group",
+			flavor: FlavorGitLab,
+			input:  "cc @gitlab-org",
+			want:   "cc [@gitlab-org](https://gitlab.com/gitlab-org)",
+		},
+		{
+			what:   "GitLab subgroup",
+			flavor: FlavorGitLab,
+			input:  "cc @gitlab-org/security, @group/sub/team/",
+			want:   "cc [@gitlab-org/security](https://gitlab.com/gitlab-org/security), [@group/sub/team](https://gitlab.com/group/sub/team)/",
+		},
+		{
+			what:   "GitLab invalid user",
+			flavor: FlavorGitLab,
+			input:  "@-foo @.foo foo@bar.com @café",
+			want:   "@-foo @.foo foo@bar.com @café",
+		},
+		{
+			what:   "GitHub user does not include dot and subgroup",
+			flavor: FlavorGitHub,
+			input:  "@foo.bar @foo/bar",
+			want:   "[@foo](https://gitlab.com/foo).bar @foo/bar",
+		},
+		{
+			what:   "GitLab commit",
+			flavor: FlavorGitLab,
+			input:  "41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:   "[`41608e5f41`](https://gitlab.com/u/r/-/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:   "GitLab merge request and milestone not following boundary",
+			flavor: FlavorGitLab,
+			input:  "hello!12 50%3 !a %",
+			want:   "hello!12 50%3 !a %",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://gitlab.com/u/r")
+			l.SetFlavor(tc.flavor)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestLinkTrailingSigils(t *testing.T) {
+	inputs := []string{
+		"@",
+		"#",
+		"foo @",
+		"foo #",
+		"@@",
+		"##",
+		"# @",
+		"@ #",
+		"_@_",
+		"*#*",
+		"**@**",
+		"`x` @",
+		"[a](b) #",
+		"foo/bar@",
+		"- @\n- #",
+		"> @\n> #",
+		"| @ | # |\n|-|-|\n| # | @ |",
+		"い@",
+		"🐶#",
+		"see f",
+		"GH-",
+		"see !",
+		"see %",
+	}
+
+	// GitLab flavor enables all triggers
+	for _, f := range []Flavor{FlavorGitHub, FlavorGitLab} {
+		for _, input := range inputs {
+			t.Run(fmt.Sprintf("%d/%s", f, input), func(t *testing.T) {
+				l := NewReflinker("https://github.com/u/r")
+				l.SetFlavor(f)
+				have := l.Link(input)
+				if have != input {
+					t.Fatalf("wanted %q but got %q", input, have)
+				}
+				if refs := l.Refs(input); len(refs) != 0 {
+					t.Fatalf("wanted no reference but got %v", refs)
+				}
+			})
+		}
+	}
+}
+
+func TestLinkIndexRefAtEndOfText(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	l.reset([]byte("foo @#"))
+	if i := l.lastIndexUserRef(4, 0, 5); i != -1 {
+		t.Errorf("wanted -1 for user reference but got %d", i)
+	}
+	if i := l.lastIndexIssueRef(5, 0, 6); i != -1 {
+		t.Errorf("wanted -1 for issue reference but got %d", i)
+	}
+}
+
+func TestLinkConcurrent(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	l.AddExtRef("FOO-", "https://example.com/foo/<num>", false)
+	inputs := []string{
+		"#1 @foo FOO-1",
+		"https://github.com/u/r/issues/2 41608e5f4109208a6ab995c58266554e6071c5b2",
+		"foo/bar@41608e5 **@bar**",
+	}
+	wants := make([]string, len(inputs))
+	for i, in := range inputs {
+		wants[i] = l.Link(in)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100*len(inputs))
+	for i := 0; i < 100; i++ {
+		for j, in := range inputs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if have := l.Link(in); have != wants[j] {
+					errs <- fmt.Sprintf("wanted %q but got %q", wants[j], have)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLinkAll(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	inputs := []string{
+		"#1 @foo",
+		"",
+		"https://github.com/u/r/issues/2 41608e5f4109208a6ab995c58266554e6071c5b2",
+		"nothing to link",
+		"foo/bar@41608e5 **@bar** GH-3",
+	}
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, fmt.Sprintf("#%d @user%d", i, i))
+	}
+
+	have := l.LinkAll(inputs)
+	want := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		want = append(want, l.Link(in))
+	}
+	if !cmp.Equal(have, want) {
+		t.Fatal(cmp.Diff(have, want))
+	}
+
+	if have := l.LinkAll(nil); len(have) != 0 {
+		t.Fatalf("wanted empty result but got %q", have)
+	}
+}
+
+func TestAppendLink(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r")
+	inputs := []string{
+		"fix #1 by @foo",
+		"nothing to link",
+		"\xef\xbb\xbf#2 https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+		"",
+	}
+
+	buf := []byte("prefix: ")
+	for _, input := range inputs {
+		src := []byte(input)
+		want := l.Link(input)
+		if have := string(l.LinkBytes(src)); have != want {
+			t.Errorf("LinkBytes: wanted %q but got %q", want, have)
+		}
+		buf = l.AppendLink(buf[:len("prefix: ")], src)
+		if have := string(buf); have != "prefix: "+want {
+			t.Errorf("AppendLink: wanted %q but got %q", "prefix: "+want, have)
+		}
+		if string(src) != input {
+			t.Errorf("input was modified: %q", src)
+		}
+	}
+
+	l.SetEnabled(false)
+	if have := string(l.AppendLink([]byte("x"), []byte("#1"))); have != "x#1" {
+		t.Errorf("wanted %q but got %q", "x#1", have)
+	}
+}
+
+const benchmarkLinkInput = `## v1.2.3
+
+- Fix #123 reported by @foo (thanks!)
+- Merge https://github.com/u/r/pull/124 by @bar
+- Revert 41608e5f4109208a6ab995c58266554e6071c5b2 and foo/bar@41608e5
+- See GH-125 and ` + "`#126`" + `
+`
+
+func BenchmarkLink(b *testing.B) {
+	l := NewReflinker("https://github.com/u/r")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Link(benchmarkLinkInput)
+	}
+}
+
+func BenchmarkAppendLink(b *testing.B) {
+	l := NewReflinker("https://github.com/u/r")
+	src := []byte(benchmarkLinkInput)
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = l.AppendLink(buf[:0], src)
+	}
+}
+
+func BenchmarkLinkManyShortcutRefLinks(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "- [foo] #%d\n", i+1)
+	}
+	sb.WriteString("\n[foo]: https://example.com\n")
+	input := sb.String()
+	l := NewReflinker("https://github.com/u/r")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Link(input)
+	}
+}
+
+func BenchmarkLinkParallel(b *testing.B) {
+	l := NewReflinker("https://github.com/u/r")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Link(benchmarkLinkInput)
+		}
+	})
+}
+
+func FuzzLink(f *testing.F) {
+	seeds := []string{
+		"#123",
+		"@foo",
+		"41608e5f4109208a6ab995c58266554e6071c5b2",
+		"foo/bar@41608e5",
+		"GH-123",
+		"https://github.com/u/r/issues/1#issuecomment-1346614286",
+		"https://github.com/foo/bar/commit/41608e5f41",
+		"**https://github.com/u/r/pull/2**",
+		"<https://github.com/u/r/issues/3>",
+		"い#1🐶@foo ぬ",
+		"- #1\n- @foo\n> #2",
+		"| #1 | @foo |\n|-|-|\n| a | b |",
+		"`#1` [#2](https://example.com) ![#3](https://example.com/a.png)",
+		"_#1_ __@foo__ *#2*",
+		"```\n#1\n```",
+		"#",
+		"@",
+		"#1#2 @foo@bar foo/bar#1#2",
+		"&#35;1 &#64;foo &amp;#2",
+		"`https://github.com/u/r/issues/1`\n\n**https://github.com/u/r/issues/1**",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := NewReflinker("https://github.com/u/r")
+		once := l.Link(input)
+		if twice := l.Link(once); once != twice {
+			t.Fatalf("linking %q again modified its output %q: %q", input, once, twice)
+		}
+	})
+}
+
+func TestLinkVersionTags(t *testing.T) {
+	tests := []struct {
+		what   string
+		prefix string
+		input  string
+		want   string
+	}{
+		{
+			what:   "version",
+			prefix: "v",
+			input:  "released v1.2.3",
+			want:   "released [v1.2.3](https://github.com/u/r/releases/tag/v1.2.3)",
+		},
+		{
+			what:   "version followed by period",
+			prefix: "v",
+			input:  "since v1.2.3.",
+			want:   "since [v1.2.3](https://github.com/u/r/releases/tag/v1.2.3).",
+		},
+		{
+			what:   "pre-release",
+			prefix: "v",
+			input:  "v1.0.0-beta.1, v1.0.0-rc1 and v2.0.0-alpha.",
+			want:   "[v1.0.0-beta.1](https://github.com/u/r/releases/tag/v1.0.0-beta.1), [v1.0.0-rc1](https://github.com/u/r/releases/tag/v1.0.0-rc1) and [v2.0.0-alpha](https://github.com/u/r/releases/tag/v2.0.0-alpha).",
+		},
+		{
+			what:   "build metadata",
+			prefix: "v",
+			input:  "v1.0.0+20130313144700",
+			want:   "[v1.0.0+20130313144700](https://github.com/u/r/releases/tag/v1.0.0+20130313144700)",
+		},
+		{
+			what:   "version not following boundary",
+			prefix: "v",
+			input:  "rev1.2.3 v1.2.3a v1.2.3.4 v1.2",
+			want:   "rev1.2.3 v1.2.3a v1.2.3.4 v1.2",
+		},
+		{
+			what:   "version in parens",
+			prefix: "v",
+			input:  "(v1.2.3)",
+			want:   "([v1.2.3](https://github.com/u/r/releases/tag/v1.2.3))",
+		},
+		{
+			what:   "version in code span",
+			prefix: "v",
+			input:  "`v1.2.3`",
+			want:   "`v1.2.3`",
+		},
+		{
+			what:   "version without prefix",
+			prefix: "",
+			input:  "1.2.3 and v1.2.3",
+			want:   "[1.2.3](https://github.com/u/r/releases/tag/1.2.3) and v1.2.3",
+		},
+		{
+			what:   "prefix with special character",
+			prefix: "release-",
+			input:  "release-1.2.3 and releasex1.2.3",
+			want:   "[release-1.2.3](https://github.com/u/r/releases/tag/release-1.2.3) and releasex1.2.3",
+		},
+		{
+			what:   "version with other references",
+			prefix: "v",
+			input:  "v1.2.3 fixes #1",
+			want:   "[v1.2.3](https://github.com/u/r/releases/tag/v1.2.3) fixes [#1](https://github.com/u/r/issues/1)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			l := NewReflinker("https://github.com/u/r")
+			if have := l.Link(tc.input); strings.Contains(have, "/releases/tag/") {
+				t.Fatalf("version was linked by default: %q", have)
+			}
+			l.LinkVersionTags(tc.prefix)
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+}
+
+func TestNewReflinkerConfig(t *testing.T) {
+	validated := []string{}
+	cfg := &ReflinkerConfig{
+		RepoURL: "https://github.com/u/r",
+		Flavor:  FlavorGitHub,
+		ExtRefs: []ExtRefConfig{
+			{Prefix: "FOO-", URL: "https://example.com/foo/<num>"},
+			{Prefix: "BAR-", URL: "https://example.com/bar/<num>", Alphanumeric: true},
+		},
+		MentionRequiresLeadingSpace: true,
+		LinkForeignHosts:            true,
+		NoIssueRefBoundaryBefore:    true,
+		UserAllowlist:               []string{"foo", "bar"},
+		IssueDenylist:               []int{1},
+		RefValidator: func(r Ref) bool {
+			validated = append(validated, r.Text)
+			return r.Text != "#3"
+		},
+		ShowFullSHA:      true,
+		VersionTagPrefix: "v",
+		LinkVersionTags:  true,
+	}
+	l, err := NewReflinkerConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "#1 v2#2 #3 @foo (@bar) @baz FOO-1 BAR-a GH-4 41608e5f4109208a6ab995c58266554e6071c5b2 v1.2.3 https://gitlab.com/x/y/issues/5"
+	want := "#1 v2[#2](https://github.com/u/r/issues/2) #3 [@foo](https://github.com/foo) (@bar) @baz " +
+		"[FOO-1](https://example.com/foo/1) [BAR-a](https://example.com/bar/a) [GH-4](https://github.com/u/r/issues/4) " +
+		"[`41608e5f4109208a6ab995c58266554e6071c5b2`](https://github.com/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) " +
+		"[v1.2.3](https://github.com/u/r/releases/tag/v1.2.3) [gitlab.com/x/y#5](https://gitlab.com/x/y/issues/5)"
+	have := l.Link(input)
+	if have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+	if len(validated) == 0 {
+		t.Fatal("validator was not called")
+	}
+}
+
+func TestNewReflinkerConfigAllFields(t *testing.T) {
+	validator := func(r Ref) bool { return true }
+	transformer := func(k RefKind, u string) string { return u }
+	resolver := func(n int) IssueKind { return IssueKindPullRequest }
+	logger := func(format string, args ...any) {}
+	comment, review := " (c)", " (r)"
+	pat := regexp.MustCompile(`TICKET-\d+`)
+
+	cfg := &ReflinkerConfig{
+		RepoURL:                     "git@ghe.example.com:github/u/r.git",
+		Flavor:                      FlavorGitLab,
+		ExtRefs:                     []ExtRefConfig{{Prefix: "FOO-", URL: "https://example.com/foo/<num>", Alphanumeric: true, NoBoundary: true}},
+		RegexpRefs:                  []RegexpRefConfig{{Pattern: pat, URL: "https://example.com/$0"}},
+		MentionRequiresLeadingSpace: true,
+		LinkForeignHosts:            true,
+		NoIssueRefBoundaryBefore:    true,
+		UserAllowlist:               []string{"foo"},
+		IssueDenylist:               []int{1},
+		RefValidator:                validator,
+		ShowFullSHA:                 true,
+		VersionTagPrefix:            "v",
+		LinkVersionTags:             true,
+		Disabled:                    true,
+		URLTransformer:              transformer,
+		MaxTagNameLength:            10,
+		LinkFirstOccurrenceOnly:     true,
+		RelativeLinks:               true,
+		RelativeKinds:               []RefKind{RefIssue},
+		AcceptUppercaseSHA:          true,
+		NoIndentedCodeBlocks:        true,
+		CommentSuffix:               &comment,
+		ReviewSuffix:                &review,
+		NormalizeFullWidth:          true,
+		IssueURLTemplate:            "https://example.com/issues/<num>",
+		CommitSHALength:             12,
+		CompareLabel:                "compare",
+		LeadingZeros:                LeadingZerosStrip,
+		IssuePRResolver:             resolver,
+		LinkFormat:                  LinkFormatOrg,
+		DebugLogger:                 logger,
+		MaxInputSize:                1024,
+		MaxUsernameLength:           20,
+		ExtRefPriority:              true,
+		UserURLTemplate:             "https://example.com/users/<user>",
+		MentionTriggers:             []string{"thanks to"},
+		BasePath:                    "/github",
+		CollapseIssueRanges:         true,
+	}
+
+	// Ensure this test is updated when a new field is added to the configuration
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Errorf("field %s is not set in this test", v.Type().Field(i).Name)
+		}
+	}
+
+	have, err := NewReflinkerConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := NewReflinker("https://ghe.example.com/github/u/r")
+	want.AddExtRefOpts("FOO-", "https://example.com/foo/<num>", ExtRefOpts{Alphanumeric: true})
+	want.AddRegexpRef(pat, "https://example.com/$0")
+	want.SetFlavor(FlavorGitLab)
+	want.SetMentionRequiresLeadingSpace(true)
+	want.LinkForeignHosts(true)
+	want.SetIssueRefRequireBoundaryBefore(false)
+	want.SetUserAllowlist([]string{"foo"})
+	want.SetIssueDenylist([]int{1})
+	want.SetRefValidator(validator)
+	want.SetShowFullSHA(true)
+	want.LinkVersionTags("v")
+	want.SetEnabled(false)
+	want.SetURLTransformer(transformer)
+	want.SetMaxTagNameLength(10)
+	want.SetLinkFirstOccurrenceOnly(true)
+	want.SetRelativeLinks(true)
+	want.SetRelativeKinds(RefIssue)
+	want.SetAcceptUppercaseSHA(true)
+	want.SetIndentedCodeBlocks(false)
+	want.SetReferenceCommentFormat(comment, review)
+	want.SetNormalizeFullWidth(true)
+	want.SetIssueURLTemplate("https://example.com/issues/<num>")
+	want.SetCommitSHALength(12)
+	want.SetCompareLabel("compare")
+	want.SetLeadingZeros(LeadingZerosStrip)
+	want.SetIssuePRResolver(resolver)
+	want.SetLinkFormat(LinkFormatOrg)
+	want.SetDebugLogger(logger)
+	want.SetMaxInputSize(1024)
+	want.SetMaxUsernameLength(20)
+	want.SetExtRefPriority(true)
+	want.SetUserURLTemplate("https://example.com/users/<user>")
+	want.SetMentionTriggers([]string{"thanks to"})
+	want.SetBasePath("/github")
+	want.SetCollapseIssueRanges(true)
+
+	opts := []cmp.Option{
+		cmp.AllowUnexported(Reflinker{}, extRef{}, regexpRef{}),
+		cmp.Comparer(func(a, b *regexp.Regexp) bool {
+			return a == nil && b == nil || a != nil && b != nil && a.String() == b.String()
+		}),
+		cmp.Comparer(func(a, b func(Ref) bool) bool { return sameFunc(a, b) }),
+		cmp.Comparer(func(a, b func(RefKind, string) string) bool { return sameFunc(a, b) }),
+		cmp.Comparer(func(a, b func(int) IssueKind) bool { return sameFunc(a, b) }),
+		cmp.Comparer(func(a, b func(string, ...any)) bool { return sameFunc(a, b) }),
+	}
+	if diff := cmp.Diff(want, have, opts...); diff != "" {
+		t.Fatalf("linker created from configuration is different from the one configured with setters:\n%s", diff)
+	}
+}
+
+func sameFunc(a, b any) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+func TestNewReflinkerConfigDefault(t *testing.T) {
+	l, err := NewReflinkerConfig(&ReflinkerConfig{RepoURL: "https://github.com/u/r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "#1 v2#2 (@foo) GH-3 41608e5f4109208a6ab995c58266554e6071c5b2 v1.2.3"
+	want := NewReflinker("https://github.com/u/r").Link(input)
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestNewReflinkerGitSuffix(t *testing.T) {
+	l := NewReflinker("https://github.com/u/r.git")
+	if l.repo != "https://github.com/u/r" {
+		t.Errorf("wanted repository URL without .git but got %q", l.repo)
+	}
+	if l.home != "https://github.com" {
+		t.Errorf("wanted home URL https://github.com but got %q", l.home)
+	}
+	input := "#1 GH-2 @foo https://github.com/u/r/issues/3"
+	want := "[#1](https://github.com/u/r/issues/1) [GH-2](https://github.com/u/r/issues/2) [@foo](https://github.com/foo) [#3](https://github.com/u/r/issues/3)"
+	if have := l.Link(input); have != want {
+		t.Fatalf("wanted %q but got %q", want, have)
+	}
+}
+
+func TestLinkBasePath(t *testing.T) {
+	l, err := NewReflinkerConfig(&ReflinkerConfig{
+		RepoURL:  "https://corp.example.com/github/u/r",
+		BasePath: "/github",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.home != "https://corp.example.com/github" {
+		t.Fatalf("unexpected home URL %q", l.home)
+	}
+
+	tests := []struct {
+		what  string
+		input string
+		want  string
+	}{
+		{
+			what:  "issue",
+			input: "#1",
+			want:  "[#1](https://corp.example.com/github/u/r/issues/1)",
+		},
+		{
+			what:  "user",
+			input: "@foo",
+			want:  "[@foo](https://corp.example.com/github/foo)",
+		},
+		{
+			what:  "commit",
+			input: "41608e5f4109208a6ab995c58266554e6071c5b2 foo/bar@41608e5",
+			want:  "[`41608e5f41`](https://corp.example.com/github/u/r/commit/41608e5f4109208a6ab995c58266554e6071c5b2) [foo/bar@`41608e5`](https://corp.example.com/github/foo/bar/commit/41608e5)",
+		},
+		{
+			what:  "cross-repository issue",
+			input: "foo/bar#2",
+			want:  "[foo/bar#2](https://corp.example.com/github/foo/bar/issues/2)",
+		},
+		{
+			what:  "URLs",
+			input: "https://corp.example.com/github/u/r/issues/3 https://corp.example.com/github/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2",
+			want:  "[#3](https://corp.example.com/github/u/r/issues/3) [foo/bar@`41608e5f41`](https://corp.example.com/github/foo/bar/commit/41608e5f4109208a6ab995c58266554e6071c5b2)",
+		},
+		{
+			what:  "URL outside base path",
+			input: "https://corp.example.com/u/r/issues/4",
+			want:  "https://corp.example.com/u/r/issues/4",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			have := l.Link(tc.input)
+			if have != tc.want {
+				t.Fatalf("wanted %q but got %q", tc.want, have)
+			}
+		})
+	}
+
+	l.SetBasePath("")
+	if l.home != "https://corp.example.com" {
+		t.Fatalf("unexpected home URL %q after removing base path", l.home)
+	}
+}
+
+func TestNewReflinkerConfigError(t *testing.T) {
+	tests := []struct {
+		what string
+		cfg  ReflinkerConfig
+		want string
+	}{
+		{
+			what: "broken URL",
+			cfg:  ReflinkerConfig{RepoURL: "https://github.com/u/r\x7f"},
+			want: "invalid repository URL",
+		},
+		{
+			what: "relative URL",
+			cfg:  ReflinkerConfig{RepoURL: "u/r"},
+			want: "must be an absolute URL",
+		},
+		{
+			what: "unknown flavor",
+			cfg:  ReflinkerConfig{RepoURL: "https://github.com/u/r", Flavor: 100},
+			want: "unknown flavor 100",
+		},
+		{
+			what: "unknown link format",
+			cfg:  ReflinkerConfig{RepoURL: "https://github.com/u/r", LinkFormat: 100},
+			want: "unknown format 100 of links",
+		},
+		{
+			what: "empty ext ref prefix",
+			cfg: ReflinkerConfig{
+				RepoURL: "https://github.com/u/r",
+				ExtRefs: []ExtRefConfig{{URL: "https://example.com/<num>"}},
+			},
+			want: "must not be empty",
+		},
+		{
+			what: "ext ref URL without placeholder",
+			cfg: ReflinkerConfig{
+				RepoURL: "https://github.com/u/r",
+				ExtRefs: []ExtRefConfig{{Prefix: "FOO-", URL: "https://example.com/"}},
+			},
+			want: "must contain <num> placeholder",
+		},
+		{
+			what: "nil regexp ref pattern",
+			cfg: ReflinkerConfig{
+				RepoURL:    "https://github.com/u/r",
+				RegexpRefs: []RegexpRefConfig{{URL: "https://example.com/$0"}},
+			},
+			want: "must not be nil",
+		},
+		{
+			what: "unknown leading zeros handling",
+			cfg: ReflinkerConfig{
+				RepoURL:      "https://github.com/u/r",
+				LeadingZeros: LeadingZeros(3),
+			},
+			want: "unknown handling 3 of issue numbers with leading zeros",
+		},
+		{
+			what: "repository outside base path",
+			cfg: ReflinkerConfig{
+				RepoURL:  "https://corp.example.com/u/r",
+				BasePath: "/github",
+			},
+			want: "must start with the base path \"/github\"",
+		},
+		{
+			what: "user URL template without placeholder",
+			cfg: ReflinkerConfig{
+				RepoURL:         "https://github.com/u/r",
+				UserURLTemplate: "mailto:someone@corp.com",
+			},
+			want: "must contain <user> placeholder",
+		},
+		{
+			what: "user URL template with whitespace",
+			cfg: ReflinkerConfig{
+				RepoURL:         "https://github.com/u/r",
+				UserURLTemplate: "https://example.com/<user> (profile)",
+			},
+			want: "must not contain whitespaces, parentheses, or angle brackets",
+		},
+		{
+			what: "user URL template without scheme",
+			cfg: ReflinkerConfig{
+				RepoURL:         "https://github.com/u/r",
+				UserURLTemplate: "<user>@corp.com",
+			},
+			want: "must be an absolute URL with scheme",
+		},
+		{
+			what: "broken user URL template",
+			cfg: ReflinkerConfig{
+				RepoURL:         "https://github.com/u/r",
+				UserURLTemplate: "https://example.com/<user>%zz",
+			},
+			want: "is not a valid URL",
+		},
+		{
+			what: "issue URL template without placeholder",
+			cfg: ReflinkerConfig{
+				RepoURL:          "https://github.com/u/r",
+				IssueURLTemplate: "https://tracker.example.com/issues",
+			},
+			want: "issue URL template \"https://tracker.example.com/issues\" must contain <num> placeholder",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.what, func(t *testing.T) {
+			_, err := NewReflinkerConfig(&tc.cfg)
+			if err == nil {
+				t.Fatal("error did not occur")
+			}
+			if msg := err.Error(); !strings.Contains(msg, tc.want) {
+				t.Fatalf("wanted %q in error message but got %q", tc.want, msg)
+			}
+		})
+	}
+}